@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var circleCiAPIV2BaseURL = "https://circleci.com/api/v2"
+
+var vcsTypesBySlugPrefix = map[string]string{
+	"gh":        "github",
+	"github":    "github",
+	"bb":        "bitbucket",
+	"bitbucket": "bitbucket",
+}
+
+// activeWorkflowStatuses are the workflow statuses which may still have jobs
+// waiting or running. Jobs of other workflows are not fetched.
+var activeWorkflowStatuses = map[string]bool{
+	"running": true,
+	"on_hold": true,
+	"failing": true,
+}
+
+// waitingJobStatuses are the job statuses which are counted as the
+// "not_running" lifecycle of API v1.1. Blocked jobs wait for the jobs they
+// require, and are counted as well, so that the queue is not smaller than with
+// API v1.1.
+var waitingJobStatuses = map[string]bool{
+	"not_running": true,
+	"queued":      true,
+	"blocked":     true,
+}
+
+// finishedJobStatuses are the job statuses which are counted as the
+// "finished" lifecycle of API v1.1.
+var finishedJobStatuses = map[string]bool{
+	"success":             true,
+	"failed":              true,
+	"retried":             true,
+	"infrastructure_fail": true,
+	"timedout":            true,
+	"terminated-unknown":  true,
+	"canceled":            true,
+	"unauthorized":        true,
+}
+
+type v2Pipeline struct {
+	ID        string    `json:"id"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"created_at"`
+	Vcs       struct {
+		Branch string `json:"branch"`
+	} `json:"vcs"`
+}
+
+type v2Workflow struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type v2Job struct {
+	Name      string `json:"name"`
+	JobNumber int    `json:"job_number"`
+	Status    string `json:"status"`
+	Type      string `json:"type"`
+}
+
+type v2PipelinePage struct {
+	Items         []*v2Pipeline `json:"items"`
+	NextPageToken string        `json:"next_page_token"`
+}
+
+type v2WorkflowPage struct {
+	Items         []*v2Workflow `json:"items"`
+	NextPageToken string        `json:"next_page_token"`
+}
+
+type v2JobPage struct {
+	Items         []*v2Job `json:"items"`
+	NextPageToken string   `json:"next_page_token"`
+}
+
+// getPipelineJobCounts collects the same counts as getJobCounts from the
+// pipelines, workflows and jobs of each project in --project-slugs.
+func getPipelineJobCounts(ctx context.Context) (*queueStats, error) {
+	stats := newQueueStats()
+	horizon := getHorizon(time.Now())
+
+	for _, slug := range targetProjectSlugs {
+		truncated, err := collectProjectPipelines(ctx, stats, slug, horizon)
+		if err != nil {
+			return stats, err
+		}
+		if truncated {
+			stats.truncated = true
+		}
+	}
+
+	return stats, nil
+}
+
+func collectProjectPipelines(ctx context.Context, stats *queueStats, slug string, horizon time.Time) (bool, error) {
+	project, err := parseProjectSlug(slug)
+	if err != nil {
+		return false, err
+	}
+
+	pageToken := ""
+	for page := 0; page < opts.MaxPages; page++ {
+		var pipelines v2PipelinePage
+		if err := getCircleCiV2(ctx, "/project/"+slug+"/pipeline", pageToken, &pipelines); err != nil {
+			return false, err
+		}
+
+		for _, pipeline := range pipelines.Items {
+			if !horizon.IsZero() && pipeline.CreatedAt.Before(horizon) {
+				return false, nil
+			}
+
+			branchJob := *project
+			branchJob.Branch = pipeline.Vcs.Branch
+			if err := collectPipeline(ctx, stats, &branchJob, pipeline); err != nil {
+				return false, err
+			}
+		}
+
+		if pipelines.NextPageToken == "" {
+			return false, nil
+		}
+		pageToken = pipelines.NextPageToken
+	}
+
+	return true, nil
+}
+
+func collectPipeline(ctx context.Context, stats *queueStats, branchJob *circleCiJob, pipeline *v2Pipeline) error {
+	stats.incrPipeline(pipeline.State, branchJob)
+	stats.addJobs([]*circleCiJob{branchJob})
+
+	workflows, err := getPipelineWorkflows(ctx, pipeline.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, workflow := range workflows {
+		stats.incrWorkflow(workflow.Status, branchJob)
+		if !activeWorkflowStatuses[workflow.Status] {
+			continue
+		}
+
+		jobs, err := getWorkflowJobs(ctx, workflow.ID)
+		if err != nil {
+			return err
+		}
+
+		var circleCiJobs []*circleCiJob
+		for _, job := range jobs {
+			// Approval jobs never occupy a container.
+			if job.Type != "build" {
+				continue
+			}
+
+			circleCiJob := *branchJob
+			circleCiJob.BuildNum = job.JobNumber
+			circleCiJob.LifeCycle = job.Status
+			if waitingJobStatuses[job.Status] {
+				circleCiJob.LifeCycle = "not_running"
+			} else if finishedJobStatuses[job.Status] {
+				circleCiJob.LifeCycle = "finished"
+			}
+			circleCiJob.Status = job.Status
+			circleCiJob.Workflows = &circleCiWorkflows{
+				WorkflowName: workflow.Name,
+				JobName:      job.Name,
+			}
+			circleCiJobs = append(circleCiJobs, &circleCiJob)
+		}
+		stats.addJobs(circleCiJobs)
+	}
+
+	return nil
+}
+
+func getPipelineWorkflows(ctx context.Context, pipelineID string) ([]*v2Workflow, error) {
+	var workflows []*v2Workflow
+
+	pageToken := ""
+	for {
+		var page v2WorkflowPage
+		if err := getCircleCiV2(ctx, "/pipeline/"+pipelineID+"/workflow", pageToken, &page); err != nil {
+			return workflows, err
+		}
+		workflows = append(workflows, page.Items...)
+
+		if page.NextPageToken == "" {
+			return workflows, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func getWorkflowJobs(ctx context.Context, workflowID string) ([]*v2Job, error) {
+	var jobs []*v2Job
+
+	pageToken := ""
+	for {
+		var page v2JobPage
+		if err := getCircleCiV2(ctx, "/workflow/"+workflowID+"/job", pageToken, &page); err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, page.Items...)
+
+		if page.NextPageToken == "" {
+			return jobs, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func getCircleCiV2(ctx context.Context, path, pageToken string, out interface{}) error {
+	u := circleCiAPIV2BaseURL + path
+	if pageToken != "" {
+		u += "?page-token=" + url.QueryEscape(pageToken)
+	}
+
+	return getCircleCiJSON(ctx, path, u, out)
+}
+
+// parseProjectSlug converts a project slug like "gh/org/repo" into a job
+// carrying the same vcs_type/username/reponame as the recent-builds API.
+func parseProjectSlug(slug string) (*circleCiJob, error) {
+	parts := strings.Split(slug, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid project slug: %s", slug)
+	}
+
+	vcsType, ok := vcsTypesBySlugPrefix[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown VCS type of project slug: %s", slug)
+	}
+
+	return &circleCiJob{
+		VcsType:  vcsType,
+		Username: parts[1],
+		Reponame: parts[2],
+	}, nil
+}
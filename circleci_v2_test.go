@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetPipelineJobCounts(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	old := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/project/gh/yuya-takeyama/jr/pipeline", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Circle-Token") != "token" {
+			t.Errorf("Circle-Token header is wrong: %q", r.Header.Get("Circle-Token"))
+		}
+		fmt.Fprintf(w, `{"items":[
+			{"id":"p1","state":"created","created_at":%q,"vcs":{"branch":"master"}},
+			{"id":"p2","state":"created","created_at":%q,"vcs":{"branch":"feature"}},
+			{"id":"p3","state":"created","created_at":%q,"vcs":{"branch":"old"}}
+		],"next_page_token":"next"}`, now, now, old)
+	})
+	mux.HandleFunc("/pipeline/p1/workflow", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[{"id":"w1","name":"build","status":"running"}],"next_page_token":null}`)
+	})
+	mux.HandleFunc("/pipeline/p2/workflow", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[{"id":"w2","name":"build","status":"success"}],"next_page_token":null}`)
+	})
+	mux.HandleFunc("/workflow/w1/job", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[
+			{"name":"test","job_number":1,"status":"running","type":"build"},
+			{"name":"lint","job_number":2,"status":"not_running","type":"build"},
+			{"name":"build","job_number":3,"status":"queued","type":"build"},
+			{"name":"deploy","job_number":4,"status":"blocked","type":"build"},
+			{"name":"hold","status":"on_hold","type":"approval"}
+		],"next_page_token":null}`)
+	})
+	mux.HandleFunc("/workflow/w2/job", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("jobs of finished workflows must not be fetched")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	originalBaseURL := circleCiAPIV2BaseURL
+	circleCiAPIV2BaseURL = server.URL
+	defer func() { circleCiAPIV2BaseURL = originalBaseURL }()
+
+	originalSlugs := targetProjectSlugs
+	targetProjectSlugs = []string{"gh/yuya-takeyama/jr"}
+	defer func() { targetProjectSlugs = originalSlugs }()
+
+	defer setPagingOptions(10, 3600)()
+
+	defer setSecretEnv("token", "")()
+
+	stats, err := getPipelineJobCounts(context.Background())
+	if err != nil {
+		t.Fatalf("getPipelineJobCounts() returned error: %s", err)
+	}
+
+	expectedRunning := 1
+	actualRunning := stats.runningCounts.getTotalCount()
+	if actualRunning != expectedRunning {
+		t.Errorf("running count is wrong: expected: %d, actual: %d", expectedRunning, actualRunning)
+	}
+
+	expectedNotRunning := 3
+	actualNotRunning := stats.notRunningCounts.getTotalCount()
+	if actualNotRunning != expectedNotRunning {
+		t.Errorf("not_running count is wrong: expected: %d, actual: %d", expectedNotRunning, actualNotRunning)
+	}
+
+	expectedLen := 2
+	actualLen := len(stats.runningCounts.jobCounts)
+	if actualLen != expectedLen {
+		t.Errorf("number of branches is wrong: expected: %d, actual: %d", expectedLen, actualLen)
+	}
+
+	for status, expected := range map[string]int{"running": 1, "success": 1} {
+		counts, ok := stats.workflowCounts[status]
+		if !ok {
+			t.Errorf("workflow count of %s is missing", status)
+		} else if actual := counts.getTotalCount(); actual != expected {
+			t.Errorf("workflow count of %s is wrong: expected: %d, actual: %d", status, expected, actual)
+		}
+	}
+
+	if stats.truncated {
+		t.Errorf("getPipelineJobCounts() result is wrong: expected not to be truncated")
+	}
+}
+
+func TestParseProjectSlug(t *testing.T) {
+	job, err := parseProjectSlug("gh/yuya-takeyama/jr")
+	if err != nil {
+		t.Fatalf("parseProjectSlug() returned error: %s", err)
+	}
+
+	expectedKey := "github/yuya-takeyama/jr/"
+	actualKey := job.toKey()
+	if actualKey != expectedKey {
+		t.Errorf("parseProjectSlug() result is wrong: expected: %s, actual: %s", expectedKey, actualKey)
+	}
+
+	for _, slug := range []string{"yuya-takeyama/jr", "svn/yuya-takeyama/jr", "gh//jr"} {
+		if _, err := parseProjectSlug(slug); err == nil {
+			t.Errorf("parseProjectSlug(%q) should return error", slug)
+		}
+	}
+}
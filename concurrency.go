@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// concurrencyLimits holds the concurrency of the plan of each org, given with
+// --concurrency. The CircleCI API does not expose it.
+type concurrencyLimits struct {
+	defaultLimit int
+	limits       map[string]int
+}
+
+var planConcurrency = &concurrencyLimits{limits: make(map[string]int)}
+
+// parseConcurrencyLimits parses a comma-separated list of "username=N", or
+// "N" for every org not listed.
+func parseConcurrencyLimits(s string) (*concurrencyLimits, error) {
+	c := &concurrencyLimits{limits: make(map[string]int)}
+	if s == "" {
+		return c, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		username, value := "", entry
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			username, value = entry[:i], entry[i+1:]
+		}
+
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid concurrency: %s", entry)
+		}
+
+		if username == "" {
+			c.defaultLimit = limit
+		} else {
+			c.limits[username] = limit
+		}
+	}
+
+	return c, nil
+}
+
+// limitOf returns the concurrency of the org, or 0 if unknown.
+func (c *concurrencyLimits) limitOf(username string) int {
+	if limit, ok := c.limits[username]; ok {
+		return limit
+	}
+
+	return c.defaultLimit
+}
+
+// usernames returns the orgs with a configured concurrency: those listed, and
+// with a concurrency for every org, those in --usernames.
+func (c *concurrencyLimits) usernames() []string {
+	var usernames []string
+	for username := range c.limits {
+		usernames = append(usernames, username)
+	}
+	if c.defaultLimit > 0 {
+		for _, username := range targetUsernames {
+			if _, ok := c.limits[username]; !ok {
+				usernames = append(usernames, username)
+			}
+		}
+	}
+
+	return usernames
+}
+
+// saturationTracker remembers since when each org has been using all of its
+// concurrency, and the vcs_type each org was last seen with.
+type saturationTracker struct {
+	mu       sync.Mutex
+	since    map[string]time.Time
+	vcsTypes map[string]string
+}
+
+var planSaturation = newSaturationTracker()
+
+func newSaturationTracker() *saturationTracker {
+	return &saturationTracker{
+		since:    make(map[string]time.Time),
+		vcsTypes: make(map[string]string),
+	}
+}
+
+// vcsTypeOf returns the vcs_type the org was last seen with, and remembers
+// vcsType instead if it is given.
+func (t *saturationTracker) vcsTypeOf(username, vcsType string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if vcsType != "" {
+		t.vcsTypes[username] = vcsType
+	}
+
+	return t.vcsTypes[username]
+}
+
+// observe returns how long the org has been saturated, or 0 if it is not.
+func (t *saturationTracker) observe(org string, saturated bool, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !saturated {
+		delete(t.since, org)
+		return 0
+	}
+
+	since, ok := t.since[org]
+	if !ok {
+		t.since[org] = now
+		return 0
+	}
+
+	return now.Sub(since)
+}
+
+// concurrencyMetrics returns utilization, headroom and saturated duration of
+// each org with a known concurrency, from containers of running builds. Orgs
+// with a configured concurrency are reported even without builds, with the
+// vcs_type they were last seen with, if any.
+func concurrencyMetrics(now time.Time, runningContainerCounts *jobCounts, limits *concurrencyLimits, tracker *saturationTracker) []sample {
+	type org struct {
+		vcsType, username string
+		running           int
+	}
+	orgs := make(map[string]*org)
+	seen := make(map[string]bool)
+
+	for _, jc := range runningContainerCounts.jobCounts {
+		key := jc.VcsType + "/" + jc.Username
+		o, ok := orgs[key]
+		if !ok {
+			o = &org{vcsType: jc.VcsType, username: jc.Username}
+			orgs[key] = o
+			seen[jc.Username] = true
+			tracker.vcsTypeOf(jc.Username, jc.VcsType)
+		}
+		o.running += jc.Count
+	}
+	for _, username := range limits.usernames() {
+		if !seen[username] {
+			vcsType := tracker.vcsTypeOf(username, "")
+			orgs[vcsType+"/"+username] = &org{vcsType: vcsType, username: username}
+		}
+	}
+
+	var metrics []sample
+	for key, o := range orgs {
+		limit := limits.limitOf(o.username)
+		if limit == 0 {
+			continue
+		}
+
+		var tags []tag
+		if o.vcsType != "" {
+			tags = append(tags, tag{"vcs_type", o.vcsType})
+		}
+		tags = append(tags, tag{"username", o.username})
+		saturatedFor := tracker.observe(key, o.running >= limit, now)
+
+		metrics = append(metrics,
+			newSample(now, concurrencyLimitMetricName, float64(limit), tags),
+			newSample(now, utilizationMetricName, float64(o.running)/float64(limit), tags),
+			newSample(now, headroomMetricName, float64(limit-o.running), tags),
+			newSample(now, saturatedDurationMetricName, saturatedFor.Seconds(), tags),
+		)
+	}
+
+	return metrics
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConcurrencyLimits(t *testing.T) {
+	limits, err := parseConcurrencyLimits("30,yuya-takeyama=80")
+	if err != nil {
+		t.Fatalf("parseConcurrencyLimits() returned error: %s", err)
+	}
+
+	cases := map[string]int{"yuya-takeyama": 80, "other": 30}
+	for username, expected := range cases {
+		if actual := limits.limitOf(username); actual != expected {
+			t.Errorf("limitOf(%s) result is wrong: expected: %d, actual: %d", username, expected, actual)
+		}
+	}
+
+	for _, s := range []string{"abc", "org=0", "org="} {
+		if _, err := parseConcurrencyLimits(s); err == nil {
+			t.Errorf("parseConcurrencyLimits(%q) should return error", s)
+		}
+	}
+}
+
+func TestConcurrencyMetrics(t *testing.T) {
+	limits, _ := parseConcurrencyLimits("yuya-takeyama=10")
+	tracker := newSaturationTracker()
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	counts := newJobCounts()
+	job := createCircleCIJobWithLifeCycle("running")
+	counts.incrBy(job, 10)
+	other := createCircleCIJobWithLifeCycle("running")
+	other.Username = "unknown-org"
+	counts.incrBy(other, 5)
+
+	concurrencyMetrics(now, counts, limits, tracker)
+	metrics := concurrencyMetrics(now.Add(time.Minute), counts, limits, tracker)
+
+	expected := map[string]float64{
+		concurrencyLimitMetricName:  10,
+		utilizationMetricName:       1,
+		headroomMetricName:          0,
+		saturatedDurationMetricName: 60,
+	}
+	if len(metrics) != len(expected) {
+		t.Fatalf("concurrencyMetrics() result is wrong: expected: %d metrics, actual: %d", len(expected), len(metrics))
+	}
+	for _, metric := range metrics {
+		if actual := metric.Value; actual != expected[metric.Name] {
+			t.Errorf("concurrencyMetrics() result of %s is wrong: expected: %f, actual: %f", metric.Name, expected[metric.Name], actual)
+		}
+	}
+
+	counts.jobCounts[job.toKey()].Count = 4
+	metrics = concurrencyMetrics(now.Add(2*time.Minute), counts, limits, tracker)
+	for _, metric := range metrics {
+		if metric.Name == saturatedDurationMetricName && metric.Value != 0 {
+			t.Errorf("saturated duration should be reset: %f", metric.Value)
+		}
+	}
+}
+
+func TestConcurrencyMetricsWithoutBuilds(t *testing.T) {
+	limits, _ := parseConcurrencyLimits("yuya-takeyama=10")
+	tracker := newSaturationTracker()
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	counts := newJobCounts()
+	counts.incrBy(createCircleCIJobWithLifeCycle("running"), 10)
+	concurrencyMetrics(now, counts, limits, tracker)
+	concurrencyMetrics(now.Add(time.Minute), counts, limits, tracker)
+
+	metrics := concurrencyMetrics(now.Add(2*time.Minute), newJobCounts(), limits, tracker)
+
+	expected := map[string]float64{
+		concurrencyLimitMetricName:  10,
+		utilizationMetricName:       0,
+		headroomMetricName:          10,
+		saturatedDurationMetricName: 0,
+	}
+	if len(metrics) != len(expected) {
+		t.Fatalf("concurrencyMetrics() result is wrong: expected: %d metrics, actual: %d", len(expected), len(metrics))
+	}
+	for _, metric := range metrics {
+		if actual := metric.Value; actual != expected[metric.Name] {
+			t.Errorf("concurrencyMetrics() result of %s is wrong: expected: %f, actual: %f", metric.Name, expected[metric.Name], actual)
+		}
+		if tags := strings.Join(tagStrings(metric.Tags), ","); tags != "vcs_type:github,username:yuya-takeyama" {
+			t.Errorf("concurrencyMetrics() tags of %s are wrong: %s", metric.Name, tags)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	datadog "github.com/zorkian/go-datadog-api"
+)
+
+// datadogSites are the sites which --datadog-site accepts. Any other endpoint
+// can be given with --datadog-url.
+var datadogSites = []string{
+	"datadoghq.com",
+	"us3.datadoghq.com",
+	"us5.datadoghq.com",
+	"datadoghq.eu",
+	"ap1.datadoghq.com",
+	"ddog-gov.com",
+}
+
+// newDatadogClient returns a client for rawurl, or the API of site if rawurl
+// is empty. Without either, the client defaults to DATADOG_HOST or
+// datadoghq.com.
+func newDatadogClient(apiKey, site, rawurl string) (*datadog.Client, error) {
+	client := datadog.NewClient(apiKey, "")
+
+	if rawurl != "" {
+		u, err := url.Parse(rawurl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid --datadog-url: %s", rawurl)
+		}
+		client.SetBaseUrl(strings.TrimRight(rawurl, "/"))
+		return client, nil
+	}
+
+	if site != "" {
+		for _, s := range datadogSites {
+			if site == s {
+				client.SetBaseUrl("https://api." + site)
+				return client, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown --datadog-site: %s (use --datadog-url for other endpoints)", site)
+	}
+
+	return client, nil
+}
+
+// datadogSink posts samples to the Datadog API as gauges.
+type datadogSink struct {
+	client *datadog.Client
+}
+
+func newDatadogSink(client *datadog.Client) *datadogSink {
+	return &datadogSink{client: client}
+}
+
+func (s *datadogSink) Name() string {
+	return "Datadog"
+}
+
+func (s *datadogSink) Send(ctx context.Context, samples []sample) error {
+	return s.client.PostMetrics(toDatadogMetrics(samples))
+}
+
+func toDatadogMetrics(samples []sample) []datadog.Metric {
+	metrics := make([]datadog.Metric, len(samples))
+	for i := range samples {
+		s := samples[i]
+		timestamp := float64(s.Timestamp.Unix())
+		metrics[i] = datadog.Metric{
+			Metric: &s.Name,
+			Points: []datadog.DataPoint{{&timestamp, &s.Value}},
+			Tags:   tagStrings(s.Tags),
+		}
+	}
+
+	return metrics
+}
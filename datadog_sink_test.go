@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewDatadogClient(t *testing.T) {
+	cases := []struct {
+		site, rawurl, expected string
+	}{
+		{"datadoghq.eu", "", "https://api.datadoghq.eu"},
+		{"datadoghq.eu", "https://dd-proxy.internal:8443/", "https://dd-proxy.internal:8443"},
+	}
+	for _, c := range cases {
+		client, err := newDatadogClient("key", c.site, c.rawurl)
+		if err != nil {
+			t.Errorf("newDatadogClient(%q, %q) returned error: %s", c.site, c.rawurl, err)
+			continue
+		}
+		if actual := client.GetBaseUrl(); actual != c.expected {
+			t.Errorf("base URL is wrong: expected: %q, actual: %q", c.expected, actual)
+		}
+	}
+
+	if _, err := newDatadogClient("key", "datadoghq.example", ""); err == nil {
+		t.Errorf("newDatadogClient() should reject unknown sites")
+	}
+	if _, err := newDatadogClient("key", "", "dd-proxy.internal"); err == nil {
+		t.Errorf("newDatadogClient() should reject URLs without scheme")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dimension is an optional tag of queue metrics, enabled with --dimensions.
+// Every enabled dimension multiplies the number of series, so only
+// resource_class and executor are enabled by default.
+type dimension struct {
+	name    string
+	valueOf func(job *circleCiJob) string
+}
+
+var availableDimensions = []*dimension{
+	{
+		name: "resource_class",
+		valueOf: func(job *circleCiJob) string {
+			return job.resourceClass()
+		},
+	},
+	{
+		name: "executor",
+		valueOf: func(job *circleCiJob) string {
+			return job.executor()
+		},
+	},
+	{
+		name: "workflow_name",
+		valueOf: func(job *circleCiJob) string {
+			if job.Workflows == nil {
+				return ""
+			}
+			return job.Workflows.WorkflowName
+		},
+	},
+	{
+		name: "job_name",
+		valueOf: func(job *circleCiJob) string {
+			if job.Workflows == nil {
+				return ""
+			}
+			return job.Workflows.JobName
+		},
+	},
+}
+
+var enabledDimensions []*dimension
+
+func parseDimensions(s string) ([]*dimension, error) {
+	var dimensions []*dimension
+	if s == "" {
+		return dimensions, nil
+	}
+
+	for _, name := range strings.Split(s, ",") {
+		d := findDimension(name)
+		if d == nil {
+			return nil, fmt.Errorf("unknown dimension: %s", name)
+		}
+		dimensions = append(dimensions, d)
+	}
+
+	return dimensions, nil
+}
+
+func findDimension(name string) *dimension {
+	for _, d := range availableDimensions {
+		if d.name == name {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// findEnabledDimension returns the dimension of --dimensions with the name, or
+// nil if it is not enabled.
+func findEnabledDimension(name string) *dimension {
+	for _, d := range enabledDimensions {
+		if d.name == name {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func dimensionValues(job *circleCiJob) []string {
+	values := make([]string, len(enabledDimensions))
+	for i, d := range enabledDimensions {
+		values[i] = d.valueOf(job)
+	}
+
+	return values
+}
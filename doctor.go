@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// exitCodeCheckFailed is used when the doctor command or the startup check
+// finds a problem.
+const exitCodeCheckFailed = 4
+
+// doctorTimeout bounds the requests to CircleCI of the checks.
+const doctorTimeout = 30 * time.Second
+
+// doctorCommand checks the credentials and options without collecting
+// metrics. It is run as "doctor" or "check-config".
+type doctorCommand struct{}
+
+// checkResult is a line of the report of the doctor command.
+type checkResult struct {
+	name   string
+	detail string
+	err    error
+	// temporary is true when err is a network or server error, which does
+	// not fail the startup check.
+	temporary bool
+}
+
+type circleCiUser struct {
+	Login string `json:"login"`
+}
+
+type circleCiCollaboration struct {
+	VcsType string `json:"vcs-type"`
+	Name    string `json:"name"`
+}
+
+// runChecks checks that the Datadog API key is valid if metrics are sent to
+// Datadog, the CircleCI token is valid, and every --usernames is visible to
+// the owner of the token.
+func runChecks(ctx context.Context, sendsToDatadog bool) []checkResult {
+	var results []checkResult
+
+	if sendsToDatadog {
+		// The client retries on its own for a minute without ctx.
+		client := *datadogClient
+		client.HttpClient = &http.Client{Timeout: doctorTimeout}
+		client.RetryTimeout = doctorTimeout
+
+		result := checkResult{name: "Datadog API key", detail: client.GetBaseUrl()}
+		valid, err := client.Validate()
+		if err != nil {
+			result.err = redactError(err)
+			result.temporary = true
+		} else if !valid {
+			result.err = errors.New("DATADOG_API_KEY is invalid")
+		}
+		results = append(results, result)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+
+	result := checkResult{name: "CircleCI API token", detail: circleCiAPIBaseURL}
+	var user circleCiUser
+	if err := getCircleCiJSON(ctx, "current user", circleCiAPIBaseURL+"/me", &user); err != nil {
+		result.err = err
+		result.temporary = isTemporaryCircleCiError(err)
+	} else if user.Login == "" {
+		result.err = errors.New("CIRCLECI_API_TOKEN is not a personal API token")
+	} else {
+		result.detail = "authenticated as " + user.Login
+	}
+	results = append(results, result)
+	if result.err != nil || len(targetUsernames) == 0 {
+		return results
+	}
+
+	var collaborations []*circleCiCollaboration
+	if err := getCircleCiJSON(ctx, "organizations", circleCiAPIV2BaseURL+"/me/collaborations", &collaborations); err != nil {
+		return append(results, checkResult{name: "--usernames", err: err, temporary: isTemporaryCircleCiError(err)})
+	}
+
+	visible := make(map[string]bool)
+	for _, c := range collaborations {
+		visible[c.Name] = true
+	}
+	for _, username := range targetUsernames {
+		result := checkResult{name: "username " + username, detail: "visible"}
+		if !visible[username] {
+			result.err = fmt.Errorf("%s is not visible to %s", username, user.Login)
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// printReport prints a line per result, and reports whether all of them
+// passed. Unless strict, temporary errors are only warned about, so that an
+// outage of CircleCI or Datadog does not stop the startup.
+func printReport(w io.Writer, results []checkResult, strict bool) (ok, warned bool) {
+	ok = true
+	for _, result := range results {
+		switch {
+		case result.err == nil:
+			fmt.Fprintf(w, "[PASS] %s: %s\n", result.name, result.detail)
+		case result.temporary && !strict:
+			warned = true
+			fmt.Fprintf(w, "[WARN] %s: %s\n", result.name, redactError(result.err))
+		default:
+			ok = false
+			fmt.Fprintf(w, "[FAIL] %s: %s\n", result.name, redactError(result.err))
+		}
+	}
+
+	return ok, warned
+}
+
+// sendsToDatadog reports whether the Datadog API sink is enabled.
+func sendsToDatadog() bool {
+	if isDebug {
+		return false
+	}
+
+	for _, name := range sinkNames() {
+		if name == "datadog" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runDoctor runs the checks and prints the report. The doctor command is
+// strict, while the startup check fails only on invalid credentials or
+// options.
+func runDoctor(ctx context.Context, w io.Writer, strict bool) bool {
+	ok, warned := printReport(w, runChecks(ctx, sendsToDatadog()), strict)
+	switch {
+	case !ok:
+		fmt.Fprintln(w, "Some checks failed.")
+	case warned:
+		fmt.Fprintln(w, "Some checks could not be completed.")
+	default:
+		fmt.Fprintln(w, "All checks passed.")
+	}
+
+	return ok
+}
+
+// logWriter writes each line to the log, for the startup check.
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		log.Print(line)
+	}
+
+	return len(p), nil
+}
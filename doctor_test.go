@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	defer setSecretEnv("circle-token", "datadog-key")()
+	defer setRetryOptions(0)()
+
+	circleCi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1.1/me":
+			fmt.Fprint(w, `{"login": "yuya-takeyama"}`)
+		case "/api/v2/me/collaborations":
+			fmt.Fprint(w, `[{"vcs-type": "github", "name": "yuya-takeyama"}]`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer circleCi.Close()
+
+	datadogAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"valid": true}`)
+	}))
+	defer datadogAPI.Close()
+
+	originalClient := datadogClient
+	originalUsernames := targetUsernames
+	originalBaseURL, originalV2BaseURL := circleCiAPIBaseURL, circleCiAPIV2BaseURL
+	defer func() {
+		datadogClient = originalClient
+		targetUsernames = originalUsernames
+		circleCiAPIBaseURL, circleCiAPIV2BaseURL = originalBaseURL, originalV2BaseURL
+	}()
+
+	client, err := newDatadogClient("datadog-key", "", datadogAPI.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datadogClient = client
+	circleCiAPIBaseURL = circleCi.URL + "/api/v1.1"
+	circleCiAPIV2BaseURL = circleCi.URL + "/api/v2"
+	targetUsernames = []string{"yuya-takeyama", "unknown-org"}
+
+	var buf bytes.Buffer
+	ok, _ := printReport(&buf, runChecks(context.Background(), true), true)
+	if ok {
+		t.Errorf("printReport() should report failure")
+	}
+
+	expected := []string{
+		"[PASS] Datadog API key: " + datadogAPI.URL,
+		"[PASS] CircleCI API token: authenticated as yuya-takeyama",
+		"[PASS] username yuya-takeyama: visible",
+		"[FAIL] username unknown-org: unknown-org is not visible to yuya-takeyama",
+	}
+	actual := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("report is wrong:\nexpected:\n%s\nactual:\n%s", strings.Join(expected, "\n"), buf.String())
+	}
+}
+
+func TestRunChecksReportsInvalidToken(t *testing.T) {
+	defer setSecretEnv("circle-token", "")()
+	defer setRetryOptions(0)()
+
+	circleCi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer circleCi.Close()
+
+	originalBaseURL := circleCiAPIBaseURL
+	circleCiAPIBaseURL = circleCi.URL
+	defer func() { circleCiAPIBaseURL = originalBaseURL }()
+
+	results := runChecks(context.Background(), false)
+	if len(results) != 1 || results[0].err == nil || results[0].temporary {
+		t.Errorf("runChecks() should report the invalid token: %v", results)
+	}
+}
+
+func TestRunChecksWarnsAboutServerErrors(t *testing.T) {
+	defer setSecretEnv("circle-token", "")()
+	defer setRetryOptions(1)()
+
+	circleCi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer circleCi.Close()
+
+	originalBaseURL := circleCiAPIBaseURL
+	circleCiAPIBaseURL = circleCi.URL
+	defer func() { circleCiAPIBaseURL = originalBaseURL }()
+
+	results := runChecks(context.Background(), false)
+	if len(results) != 1 || !results[0].temporary {
+		t.Fatalf("runChecks() should report the server error as temporary: %v", results)
+	}
+
+	var buf bytes.Buffer
+	if ok, warned := printReport(&buf, results, false); !ok || !warned {
+		t.Errorf("printReport() should only warn about a temporary error: %s", buf.String())
+	}
+	if ok, _ := printReport(&buf, results, true); ok {
+		t.Errorf("printReport() should report failure of a temporary error when strict")
+	}
+}
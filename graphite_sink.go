@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const graphiteTimeout = 10 * time.Second
+
+// graphiteTemplate builds the dotted path of a sample from placeholders of
+// {name} and its tags, e.g. circleci.queue.{username}.{reponame}.{lifecycle}.
+type graphiteTemplate struct {
+	// metricName is the only metric the template applies to, or empty for
+	// every metric.
+	metricName string
+	template   string
+}
+
+var graphitePlaceholderRegexp = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// parseGraphiteTemplates parses the templates given as [metric_name=]template.
+func parseGraphiteTemplates(values []string) ([]graphiteTemplate, error) {
+	var templates []graphiteTemplate
+	for _, value := range values {
+		t := graphiteTemplate{template: value}
+		if i := strings.Index(value, "="); i >= 0 {
+			t.metricName, t.template = value[:i], value[i+1:]
+		}
+		if t.template == "" {
+			return nil, fmt.Errorf("invalid --graphite-template: %s", value)
+		}
+		// Otherwise every metric would be written to the same paths.
+		if t.metricName == "" && !strings.Contains(t.template, "{name}") {
+			return nil, fmt.Errorf("--graphite-template without metric_name= must contain {name}: %s", value)
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, nil
+}
+
+// graphiteSink writes samples in the plaintext protocol of Carbon over TCP,
+// reconnecting when the connection is broken.
+type graphiteSink struct {
+	address   string
+	templates []graphiteTemplate
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newGraphiteSink(address string, templates []graphiteTemplate) (*graphiteSink, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid --graphite-address: %s", err)
+	}
+
+	return &graphiteSink{address: address, templates: templates}, nil
+}
+
+func (s *graphiteSink) Name() string {
+	return "Graphite"
+}
+
+// graphiteSummedMetrics are the counts which are summed when a template maps
+// several samples of them to the same path, e.g. every branch of a repository.
+var graphiteSummedMetrics = map[string]bool{
+	runningMetricName:                 true,
+	notRunningMetricName:              true,
+	runningContainersMetricName:       true,
+	notRunningContainersMetricName:    true,
+	lifeCycleMetricName:               true,
+	statusMetricName:                  true,
+	resourceClassRunningMetricName:    true,
+	resourceClassNotRunningMetricName: true,
+	workflowMetricName:                true,
+	pipelineMetricName:                true,
+	finishedMetricName:                true,
+}
+
+// graphiteMaxMetrics are the ages of which the oldest is kept instead.
+var graphiteMaxMetrics = map[string]bool{
+	oldestAgeMetricName:    true,
+	orgOldestAgeMetricName: true,
+}
+
+// graphitePoint is a line written to Carbon.
+type graphitePoint struct {
+	name      string
+	path      string
+	timestamp int64
+	value     float64
+}
+
+// Send writes every sample at once. Carbon keeps only the last value written
+// to a path, so samples which a template maps to the same path are combined:
+// counts are summed and ages are maxed. Samples of any other metric, or of
+// another metric, are dropped with a log. If the connection kept from the
+// previous check turns out to be broken, it reconnects and writes again once.
+func (s *graphiteSink) Send(ctx context.Context, samples []sample) error {
+	var points []*graphitePoint
+	indexes := make(map[string]int)
+	var collided []string
+	for _, m := range samples {
+		path := s.path(m)
+		key := path + " " + strconv.FormatInt(m.Timestamp.Unix(), 10)
+		i, ok := indexes[key]
+		if !ok {
+			indexes[key] = len(points)
+			points = append(points, &graphitePoint{name: m.Name, path: path, timestamp: m.Timestamp.Unix(), value: m.Value})
+			continue
+		}
+
+		p := points[i]
+		switch {
+		case p.name == m.Name && graphiteSummedMetrics[m.Name]:
+			p.value += m.Value
+		case p.name == m.Name && graphiteMaxMetrics[m.Name]:
+			if m.Value > p.value {
+				p.value = m.Value
+			}
+		default:
+			collided = append(collided, path)
+		}
+	}
+	if len(collided) > 0 {
+		log.Printf("dropped %d samples written to the same Graphite path as another, e.g. %s; add the tags telling them apart to --graphite-template", len(collided), collided[0])
+	}
+
+	var buf bytes.Buffer
+	for _, p := range points {
+		buf.WriteString(p.path + " " + strconv.FormatFloat(p.value, 'f', -1, 64) + " " + strconv.FormatInt(p.timestamp, 10) + "\n")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reused := s.conn != nil
+	err := s.write(buf.Bytes())
+	if err != nil && reused {
+		err = s.write(buf.Bytes())
+	}
+
+	return err
+}
+
+func (s *graphiteSink) write(data []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.address, graphiteTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to connect to Graphite: %s", err)
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+	if _, err := s.conn.Write(data); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("failed to write to Graphite: %s", err)
+	}
+
+	return nil
+}
+
+// path applies the first template for the metric, or else the first one for
+// every metric. Without any, the path is the metric name followed by its tag
+// values.
+func (s *graphiteSink) path(m sample) string {
+	for _, t := range s.templates {
+		if t.metricName == m.Name {
+			return applyGraphiteTemplate(t.template, m)
+		}
+	}
+	for _, t := range s.templates {
+		if t.metricName == "" {
+			return applyGraphiteTemplate(t.template, m)
+		}
+	}
+
+	// Dimensions without a value are left out of the tags of a branch, but
+	// still get a node, so that every node keeps its position.
+	_, hasBranch := graphiteTagValue(m.Tags, "branch")
+	path := m.Name
+	for _, t := range m.Tags {
+		if hasBranch && findEnabledDimension(t.Key) != nil {
+			continue
+		}
+		path += "." + escapeGraphiteNode(t.Value)
+		if t.Key == "branch" {
+			for _, d := range enabledDimensions {
+				value, _ := graphiteTagValue(m.Tags, d.name)
+				path += "." + escapeGraphiteNode(value)
+			}
+		}
+	}
+
+	return path
+}
+
+func applyGraphiteTemplate(template string, m sample) string {
+	return graphitePlaceholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		key := placeholder[1 : len(placeholder)-1]
+		if key == "name" {
+			return m.Name
+		}
+		value, _ := graphiteTagValue(m.Tags, key)
+
+		return escapeGraphiteNode(value)
+	})
+}
+
+// graphiteTagValue returns the value of the tag, and whether it is given.
+func graphiteTagValue(tags []tag, key string) (string, bool) {
+	for _, t := range tags {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+
+	return "", false
+}
+
+var graphiteNodeRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// escapeGraphiteNode makes a tag value a single node of a path. Dots would
+// split it into several nodes, and slashes would be directories of Whisper
+// files.
+func escapeGraphiteNode(value string) string {
+	if value == "" {
+		return "none"
+	}
+
+	return graphiteNodeRegexp.ReplaceAllString(value, "_")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGraphiteSinkReconnects(t *testing.T) {
+	listener, lines := listenGraphite(t)
+	defer listener.Close()
+
+	sink, err := newGraphiteSink(listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("newGraphiteSink() returned error: %s", err)
+	}
+
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	samples := []sample{newSample(now, runningMetricName, 1, []tag{{"reponame", "jr"}, {"branch", "feature/v1.0"}})}
+	if err := sink.Send(context.Background(), samples); err != nil {
+		t.Fatalf("Send() returned error: %s", err)
+	}
+
+	expected := "circleci.queue.running.jr.feature_v1_0 1 1538352000"
+	if actual := receiveLine(t, lines); actual != expected {
+		t.Errorf("line is wrong: expected: %q, actual: %q", expected, actual)
+	}
+
+	// Break the connection kept for the next check.
+	sink.conn.Close()
+
+	if err := sink.Send(context.Background(), samples); err != nil {
+		t.Fatalf("Send() should reconnect: %s", err)
+	}
+	if actual := receiveLine(t, lines); actual != expected {
+		t.Errorf("line is wrong: expected: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestGraphiteSinkKeepsNodesOfEmptyDimensions(t *testing.T) {
+	dimensions, err := parseDimensions("resource_class,executor")
+	if err != nil {
+		t.Fatalf("parseDimensions() returned error: %s", err)
+	}
+	original := enabledDimensions
+	enabledDimensions = dimensions
+	defer func() { enabledDimensions = original }()
+
+	sink, err := newGraphiteSink("localhost:2003", nil)
+	if err != nil {
+		t.Fatalf("newGraphiteSink() returned error: %s", err)
+	}
+
+	job := createCircleCIJobWithLifeCycle("running")
+	job.Picard = &circleCiPicard{Executor: "docker"}
+	tags := append(newJobCount(job).toTags(), tag{"lifecycle", "running"})
+
+	expected := "circleci.queue.count.github.yuya-takeyama.jr.master.none.docker.running"
+	if actual := sink.path(newSample(time.Now(), lifeCycleMetricName, 1, tags)); actual != expected {
+		t.Errorf("path() result is wrong: expected: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestParseGraphiteTemplatesRequiresName(t *testing.T) {
+	if _, err := parseGraphiteTemplates([]string{"circleci.queue.{username}.{reponame}.{lifecycle}"}); err == nil {
+		t.Errorf("parseGraphiteTemplates() should return error for a template for every metric without {name}")
+	}
+	if _, err := parseGraphiteTemplates([]string{lifeCycleMetricName + "=circleci.queue.{username}.{reponame}.{lifecycle}"}); err != nil {
+		t.Errorf("parseGraphiteTemplates() returned error for a template for a metric: %s", err)
+	}
+}
+
+func TestGraphiteSinkAppliesTemplates(t *testing.T) {
+	templates, err := parseGraphiteTemplates([]string{
+		lifeCycleMetricName + "=circleci.queue.{username}.{reponame}.{lifecycle}",
+		"ci.{name}.{reponame}.{unknown}",
+	})
+	if err != nil {
+		t.Fatalf("parseGraphiteTemplates() returned error: %s", err)
+	}
+	sink, err := newGraphiteSink("localhost:2003", templates)
+	if err != nil {
+		t.Fatalf("newGraphiteSink() returned error: %s", err)
+	}
+
+	tags := []tag{{"username", "yuya.takeyama"}, {"reponame", "jr"}, {"lifecycle", "queued"}}
+	cases := map[string]string{
+		lifeCycleMetricName: "circleci.queue.yuya_takeyama.jr.queued",
+		runningMetricName:   "ci.circleci.queue.running.jr.none",
+	}
+	for metricName, expected := range cases {
+		if actual := sink.path(newSample(time.Now(), metricName, 1, tags)); actual != expected {
+			t.Errorf("path() result is wrong: expected: %q, actual: %q", expected, actual)
+		}
+	}
+
+	// The template for the metric wins even when given after a generic one.
+	templates, err = parseGraphiteTemplates([]string{
+		"ci.{name}.{reponame}",
+		runningMetricName + "=specific.{reponame}",
+	})
+	if err != nil {
+		t.Fatalf("parseGraphiteTemplates() returned error: %s", err)
+	}
+	sink, err = newGraphiteSink("localhost:2003", templates)
+	if err != nil {
+		t.Fatalf("newGraphiteSink() returned error: %s", err)
+	}
+
+	cases = map[string]string{
+		runningMetricName:    "specific.jr",
+		notRunningMetricName: "ci.circleci.queue.not_running.jr",
+	}
+	for metricName, expected := range cases {
+		if actual := sink.path(newSample(time.Now(), metricName, 1, tags)); actual != expected {
+			t.Errorf("path() result is wrong: expected: %q, actual: %q", expected, actual)
+		}
+	}
+}
+
+func TestGraphiteSinkCombinesSamplesOfSamePath(t *testing.T) {
+	listener, lines := listenGraphite(t)
+	defer listener.Close()
+
+	templates, err := parseGraphiteTemplates([]string{"{name}.{reponame}"})
+	if err != nil {
+		t.Fatalf("parseGraphiteTemplates() returned error: %s", err)
+	}
+	sink, err := newGraphiteSink(listener.Addr().String(), templates)
+	if err != nil {
+		t.Fatalf("newGraphiteSink() returned error: %s", err)
+	}
+
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	master := []tag{{"reponame", "jr"}, {"branch", "master"}}
+	feature := []tag{{"reponame", "jr"}, {"branch", "feature"}}
+	samples := []sample{
+		newSample(now, runningMetricName, 2, master),
+		newSample(now, runningMetricName, 3, feature),
+		newSample(now, notRunningMetricName, 1, master),
+		newSample(now, oldestAgeMetricName, 30, master),
+		newSample(now, oldestAgeMetricName, 90, feature),
+		newSample(now, waitTimeMetricName+".p95", 10, master),
+		newSample(now, waitTimeMetricName+".p95", 20, feature),
+	}
+	if err := sink.Send(context.Background(), samples); err != nil {
+		t.Fatalf("Send() returned error: %s", err)
+	}
+
+	// Counts are summed and ages are maxed, but percentiles cannot be
+	// combined, so only the first one is written.
+	for _, expected := range []string{
+		"circleci.queue.running.jr 5 1538352000",
+		"circleci.queue.not_running.jr 1 1538352000",
+		"circleci.queue.oldest_age.jr 90 1538352000",
+		"circleci.build.wait_time.p95.jr 10 1538352000",
+	} {
+		if actual := receiveLine(t, lines); actual != expected {
+			t.Errorf("line is wrong: expected: %q, actual: %q", expected, actual)
+		}
+	}
+}
+
+// listenGraphite accepts connections like Carbon, and sends every line
+// received to the returned channel.
+func listenGraphite(t *testing.T) (net.Listener, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+
+	return listener, lines
+}
+
+func receiveLine(t *testing.T, lines chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a line")
+		return ""
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// circleCiHTTPClient is used for every request to the CircleCI API.
+var circleCiHTTPClient = http.DefaultClient
+
+// configureCircleCi applies the CircleCI Server, proxy and TLS options to the
+// API base URLs and circleCiHTTPClient.
+func configureCircleCi() error {
+	host := strings.TrimRight(opts.CircleCiHost, "/")
+	if _, err := url.ParseRequestURI(host); err != nil {
+		return fmt.Errorf("invalid --circleci-host: %s", err)
+	}
+	circleCiAPIBaseURL = host + "/api/v1.1"
+	circleCiAPIV2BaseURL = host + "/api/v2"
+
+	client, err := newCircleCiHTTPClient()
+	if err != nil {
+		return err
+	}
+	circleCiHTTPClient = client
+
+	return nil
+}
+
+// newCircleCiRequest builds a request to the CircleCI API. The token is sent
+// in the Circle-Token header so that it never appears in URLs.
+func newCircleCiRequest(method, rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return nil, redactError(err)
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Circle-Token", os.Getenv("CIRCLECI_API_TOKEN"))
+
+	return req, nil
+}
+
+// getCircleCiJSON gets rawurl from the CircleCI API and decodes the JSON
+// response into out. Network errors, 408, 429, 5xx and broken responses are
+// retried.
+func getCircleCiJSON(ctx context.Context, path, rawurl string, out interface{}) error {
+	return doCircleCiJSON(ctx, "GET", "get "+path+" from", rawurl, out)
+}
+
+// postCircleCi posts to rawurl of the CircleCI API, retrying like
+// getCircleCiJSON. The response is discarded.
+func postCircleCi(ctx context.Context, action, rawurl string) error {
+	return doCircleCiJSON(ctx, "POST", action+" on", rawurl, nil)
+}
+
+// doCircleCiJSON sends a request to rawurl and decodes the JSON response into
+// out unless it is nil. action describes the request in errors, as in "failed
+// to get recent builds from CircleCI API".
+func doCircleCiJSON(ctx context.Context, method, action, rawurl string, out interface{}) error {
+	return retryCircleCi(ctx, func() error {
+		req, err := newCircleCiRequest(method, rawurl)
+		if err != nil {
+			return backoff.Permanent(fmt.Errorf("failed to build HTTP request to CircleCI API: %s", err))
+		}
+
+		res, err := circleCiHTTPClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to %s CircleCI API: %s", action, redactError(err))
+		}
+
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			err := &circleCiStatusError{action: action, status: res.Status, statusCode: res.StatusCode}
+			if !isRetryableStatus(res.StatusCode) {
+				return backoff.Permanent(err)
+			}
+			return &retryAfterError{
+				err:        err,
+				retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+			}
+		}
+
+		if out == nil {
+			return nil
+		}
+
+		// Read the whole body first so that a broken response does not leave
+		// out half-decoded before retrying.
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response from CircleCI API: %s", redactError(err))
+		}
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("failed to parse response from CircleCI API: %s", redactError(err))
+		}
+
+		return nil
+	})
+}
+
+// circleCiStatusError is returned for a response of CircleCI API with a
+// status other than 200.
+type circleCiStatusError struct {
+	action     string
+	status     string
+	statusCode int
+}
+
+func (e *circleCiStatusError) Error() string {
+	return fmt.Sprintf("failed to %s CircleCI API: %s", e.action, e.status)
+}
+
+// isTemporaryCircleCiError reports whether err may go away by itself, i.e. it
+// is not a response with a status which is not retried, such as 401.
+func isTemporaryCircleCiError(err error) bool {
+	statusErr, ok := err.(*circleCiStatusError)
+
+	return !ok || isRetryableStatus(statusErr.statusCode)
+}
+
+func newCircleCiHTTPClient() (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if opts.HTTPSProxy != "" {
+		proxyURL, err := url.Parse(opts.HTTPSProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --https-proxy: %s", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newCircleCiTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Same as http.DefaultTransport except for Proxy and TLSClientConfig.
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func newCircleCiTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if opts.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := ioutil.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --ca-cert: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to read --ca-cert: no PEM certificates found in %s", opts.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, fmt.Errorf("--client-cert and --client-key must be specified together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestNewCircleCiHTTPClientTrustsCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "ca-cert")
+	if err != nil {
+		t.Fatalf("failed to create CA file: %s", err)
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile.Close()
+
+	original := opts
+	defer func() { opts = original }()
+
+	client, err := newCircleCiHTTPClient()
+	if err != nil {
+		t.Fatalf("newCircleCiHTTPClient() returned error: %s", err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Errorf("request to the server should fail without --ca-cert")
+	}
+
+	opts.CACert = caFile.Name()
+	client, err = newCircleCiHTTPClient()
+	if err != nil {
+		t.Fatalf("newCircleCiHTTPClient() returned error: %s", err)
+	}
+	if _, err := client.Get(server.URL); err != nil {
+		t.Errorf("request to the server should succeed with --ca-cert: %s", err)
+	}
+}
+
+func TestNewCircleCiHTTPClientRequiresClientKey(t *testing.T) {
+	original := opts
+	defer func() { opts = original }()
+
+	opts.ClientCert = "client.pem"
+	if _, err := newCircleCiHTTPClient(); err == nil {
+		t.Errorf("newCircleCiHTTPClient() should return error without --client-key")
+	}
+}
+
+func TestConfigureCircleCiSetsBaseURLs(t *testing.T) {
+	original := opts
+	originalBaseURL, originalV2BaseURL, originalClient := circleCiAPIBaseURL, circleCiAPIV2BaseURL, circleCiHTTPClient
+	defer func() {
+		opts = original
+		circleCiAPIBaseURL, circleCiAPIV2BaseURL, circleCiHTTPClient = originalBaseURL, originalV2BaseURL, originalClient
+	}()
+
+	opts.CircleCiHost = "https://circleci.example.com/"
+	if err := configureCircleCi(); err != nil {
+		t.Fatalf("configureCircleCi() returned error: %s", err)
+	}
+
+	expectedBaseURL := "https://circleci.example.com/api/v1.1"
+	if circleCiAPIBaseURL != expectedBaseURL {
+		t.Errorf("configureCircleCi() result is wrong: expected: %s, actual: %s", expectedBaseURL, circleCiAPIBaseURL)
+	}
+
+	expectedV2BaseURL := "https://circleci.example.com/api/v2"
+	if circleCiAPIV2BaseURL != expectedV2BaseURL {
+		t.Errorf("configureCircleCi() result is wrong: expected: %s, actual: %s", expectedV2BaseURL, circleCiAPIV2BaseURL)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const influxDBTimeout = 30 * time.Second
+
+// influxDBBatchSize is the number of lines written per request, as
+// recommended by InfluxDB.
+const influxDBBatchSize = 5000
+
+// influxDBSink writes samples in the line protocol to the HTTP write endpoint
+// of InfluxDB. Each sample is written as the measurement of its name with a
+// "value" field, tagged with its tags.
+type influxDBSink struct {
+	writeURL string
+	// authorize adds the credentials of InfluxDB 1.x or 2.x to a request.
+	authorize func(req *http.Request)
+	client    *http.Client
+}
+
+// newInfluxDBSink returns a sink writing to bucket in org of InfluxDB 2.x
+// with INFLUXDB_TOKEN, or if bucket is empty, to database of InfluxDB 1.x
+// with INFLUXDB_USERNAME and INFLUXDB_PASSWORD.
+func newInfluxDBSink(baseURL, database, org, bucket string) (*influxDBSink, error) {
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid --influxdb-url: %s", err)
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	s := &influxDBSink{client: &http.Client{Timeout: influxDBTimeout}}
+
+	if bucket != "" {
+		if org == "" {
+			return nil, fmt.Errorf("--influxdb-org is required with --influxdb-bucket")
+		}
+		s.writeURL = baseURL + "/api/v2/write?" + url.Values{
+			"org":       {org},
+			"bucket":    {bucket},
+			"precision": {"s"},
+		}.Encode()
+		s.authorize = func(req *http.Request) {
+			if token := os.Getenv("INFLUXDB_TOKEN"); token != "" {
+				req.Header.Set("Authorization", "Token "+token)
+			}
+		}
+		return s, nil
+	}
+
+	if database == "" {
+		return nil, fmt.Errorf("--influxdb-database or --influxdb-bucket is required with --sink=influxdb")
+	}
+	s.writeURL = baseURL + "/write?" + url.Values{
+		"db":        {database},
+		"precision": {"s"},
+	}.Encode()
+	s.authorize = func(req *http.Request) {
+		if username := os.Getenv("INFLUXDB_USERNAME"); username != "" {
+			req.SetBasicAuth(username, os.Getenv("INFLUXDB_PASSWORD"))
+		}
+	}
+
+	return s, nil
+}
+
+func (s *influxDBSink) Name() string {
+	return "InfluxDB"
+}
+
+// Send writes all samples of a check in as few requests as possible.
+func (s *influxDBSink) Send(ctx context.Context, samples []sample) error {
+	for start := 0; start < len(samples); start += influxDBBatchSize {
+		end := start + influxDBBatchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+
+		if err := s.write(ctx, samples[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *influxDBSink) write(ctx context.Context, samples []sample) error {
+	var body bytes.Buffer
+	for _, m := range samples {
+		body.WriteString(formatInfluxDBLine(m))
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest("POST", s.writeURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	s.authorize(req)
+
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("InfluxDB returned %s: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+var (
+	influxDBMeasurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxDBTagReplacer         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// formatInfluxDBLine formats a sample as a line of the line protocol. Tags
+// are sorted by key as InfluxDB recommends, and empty tags are left out since
+// the line protocol does not allow them.
+func formatInfluxDBLine(m sample) string {
+	tags := make([]tag, 0, len(m.Tags))
+	for _, t := range m.Tags {
+		if t.Value != "" {
+			tags = append(tags, t)
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].Key < tags[j].Key
+	})
+
+	line := influxDBMeasurementReplacer.Replace(m.Name)
+	for _, t := range tags {
+		line += "," + influxDBTagReplacer.Replace(t.Key) + "=" + influxDBTagReplacer.Replace(t.Value)
+	}
+
+	return line + " value=" + strconv.FormatFloat(m.Value, 'f', -1, 64) + " " + strconv.FormatInt(m.Timestamp.Unix(), 10)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxDBSinkWritesToV2(t *testing.T) {
+	defer setInfluxDBEnv("token", "", "")()
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("org") != "org" || r.URL.Query().Get("bucket") != "ci" || r.URL.Query().Get("precision") != "s" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "Token token" {
+			t.Errorf("Authorization header is wrong: %q", r.Header.Get("Authorization"))
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := newInfluxDBSink(server.URL, "", "org", "ci")
+	if err != nil {
+		t.Fatalf("newInfluxDBSink() returned error: %s", err)
+	}
+
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	samples := []sample{
+		newSample(now, runningMetricName, 1, newJobCount(createCircleCIJobWithLifeCycle("running")).toTags()),
+		newSample(now, truncatedMetricName, 0, nil),
+	}
+	if err := sink.Send(context.Background(), samples); err != nil {
+		t.Fatalf("Send() returned error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines should be written in one request: %q", body)
+	}
+	if !strings.HasPrefix(lines[0], "circleci.queue.running,branch=master,") || !strings.HasSuffix(lines[0], ",vcs_type=github value=1 1538352000") {
+		t.Errorf("line is wrong: %q", lines[0])
+	}
+	if lines[1] != "circleci.queue.truncated value=0 1538352000" {
+		t.Errorf("line is wrong: %q", lines[1])
+	}
+}
+
+func TestInfluxDBSinkWritesToV1(t *testing.T) {
+	defer setInfluxDBEnv("", "user", "pass")()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/write" || r.URL.Query().Get("db") != "ci" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "pass" {
+			t.Errorf("basic auth is wrong: %q, %q", username, password)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"unable to parse"}`))
+	}))
+	defer server.Close()
+
+	sink, err := newInfluxDBSink(server.URL, "ci", "", "")
+	if err != nil {
+		t.Fatalf("newInfluxDBSink() returned error: %s", err)
+	}
+
+	err = sink.Send(context.Background(), []sample{newSample(time.Now(), runningMetricName, 1, nil)})
+	if err == nil || !strings.Contains(err.Error(), "unable to parse") {
+		t.Errorf("Send() should return the error of InfluxDB: %v", err)
+	}
+}
+
+func TestFormatInfluxDBLineEscapes(t *testing.T) {
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	m := newSample(now, runningMetricName, 1.5, []tag{{"reponame", "my repo"}, {"branch", "a,b=c"}, {"executor", ""}})
+
+	expected := `circleci.queue.running,branch=a\,b\=c,reponame=my\ repo value=1.5 1538352000`
+	if actual := formatInfluxDBLine(m); actual != expected {
+		t.Errorf("formatInfluxDBLine() result is wrong: expected: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestNewInfluxDBSinkRequiresDatabase(t *testing.T) {
+	if _, err := newInfluxDBSink("http://localhost:8086", "", "", ""); err == nil {
+		t.Errorf("newInfluxDBSink() should return error")
+	}
+	if _, err := newInfluxDBSink("http://localhost:8086", "", "", "circleci"); err == nil {
+		t.Errorf("newInfluxDBSink() should return error without org for a bucket")
+	}
+}
+
+func setInfluxDBEnv(token, username, password string) func() {
+	original := map[string]string{
+		"INFLUXDB_TOKEN":    os.Getenv("INFLUXDB_TOKEN"),
+		"INFLUXDB_USERNAME": os.Getenv("INFLUXDB_USERNAME"),
+		"INFLUXDB_PASSWORD": os.Getenv("INFLUXDB_PASSWORD"),
+	}
+	os.Setenv("INFLUXDB_TOKEN", token)
+	os.Setenv("INFLUXDB_USERNAME", username)
+	os.Setenv("INFLUXDB_PASSWORD", password)
+
+	return func() {
+		for key, value := range original {
+			os.Setenv(key, value)
+		}
+	}
+}
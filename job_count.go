@@ -3,8 +3,6 @@ package main
 import (
 	"fmt"
 	"time"
-
-	datadog "github.com/zorkian/go-datadog-api"
 )
 
 type jobCount struct {
@@ -12,7 +10,55 @@ type jobCount struct {
 	Username string
 	Reponame string
 	Branch   string
-	Count    int
+	// Dimensions holds the values of enabledDimensions.
+	Dimensions []string
+	Count      int
+}
+
+func newJobCount(job *circleCiJob) *jobCount {
+	return &jobCount{
+		VcsType:    job.VcsType,
+		Username:   job.Username,
+		Reponame:   job.Reponame,
+		Branch:     job.Branch,
+		Dimensions: dimensionValues(job),
+		Count:      0,
+	}
+}
+
+// toKey identifies the series of jc. Dimension values are separated with NUL,
+// which cannot appear in them, since branch names may contain slashes.
+func (jc *jobCount) toKey() string {
+	key := jc.toProjectKey()
+	for _, value := range jc.Dimensions {
+		key += "\x00" + value
+	}
+
+	return key
+}
+
+// toProjectKey is toKey without the dimension values.
+func (jc *jobCount) toProjectKey() string {
+	return fmt.Sprintf("%s/%s/%s/%s", jc.VcsType, jc.Username, jc.Reponame, jc.Branch)
+}
+
+func (jc *jobCount) toTags() []tag {
+	tags := []tag{
+		{"vcs_type", jc.VcsType},
+		{"username", jc.Username},
+		{"reponame", jc.Reponame},
+		{"branch", jc.Branch},
+	}
+	// An empty value, e.g. resource_class of a build without picard, is left
+	// out rather than sent as an empty tag, which every sink handles
+	// differently.
+	for i, d := range enabledDimensions {
+		if i < len(jc.Dimensions) && jc.Dimensions[i] != "" {
+			tags = append(tags, tag{d.name, jc.Dimensions[i]})
+		}
+	}
+
+	return tags
 }
 
 type jobCounts struct {
@@ -31,41 +77,43 @@ func (o *jobCounts) ensure(job *circleCiJob) *jobCount {
 		return jc
 	}
 
-	jc := &jobCount{
-		VcsType:  job.VcsType,
-		Username: job.Username,
-		Reponame: job.Reponame,
-		Branch:   job.Branch,
-		Count:    0,
-	}
+	jc := newJobCount(job)
 	o.jobCounts[key] = jc
 
 	return jc
 }
 
+// ensureZero adds a zero count of the same project, branch and dimensions as
+// jc unless it is already counted.
+func (o *jobCounts) ensureZero(jc *jobCount) {
+	key := jc.toKey()
+	if _, ok := o.jobCounts[key]; ok {
+		return
+	}
+
+	zero := *jc
+	zero.Count = 0
+	o.jobCounts[key] = &zero
+}
+
 func (o *jobCounts) incr(job *circleCiJob) {
+	o.incrBy(job, 1)
+}
+
+func (o *jobCounts) incrBy(job *circleCiJob, n int) {
 	jc := o.ensure(job)
-	jc.Count++
+	jc.Count += n
 }
 
-func (o *jobCounts) toMetrics(now time.Time, metricName string) []datadog.Metric {
-	metrics := make([]datadog.Metric, len(o.jobCounts))
-	timestamp := float64(now.Unix())
+func (o *jobCounts) toMetrics(now time.Time, metricName string) []sample {
+	return o.toMetricsWithTags(now, metricName, nil)
+}
+
+func (o *jobCounts) toMetricsWithTags(now time.Time, metricName string, extraTags []tag) []sample {
+	metrics := make([]sample, 0, len(o.jobCounts))
 
-	i := 0
 	for _, jobCount := range o.jobCounts {
-		count := float64(jobCount.Count)
-		metrics[i] = datadog.Metric{
-			Metric: &metricName,
-			Points: []datadog.DataPoint{{&timestamp, &count}},
-			Tags: []string{
-				fmt.Sprintf("vcs_type:%s", jobCount.VcsType),
-				fmt.Sprintf("username:%s", jobCount.Username),
-				fmt.Sprintf("reponame:%s", jobCount.Reponame),
-				fmt.Sprintf("branch:%s", jobCount.Branch),
-			},
-		}
-		i++
+		metrics = append(metrics, newSample(now, metricName, float64(jobCount.Count), append(jobCount.toTags(), extraTags...)))
 	}
 
 	return metrics
@@ -80,3 +128,11 @@ func (o *jobCounts) getTotalCount() int {
 
 	return cnt
 }
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
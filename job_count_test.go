@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestJobCountsIncrOnce(t *testing.T) {
 	jobCounts := newJobCounts()
@@ -84,6 +87,86 @@ func TestJobCountsEnsureTwice(t *testing.T) {
 	}
 }
 
+func TestJobCountsIncrWithDimensions(t *testing.T) {
+	dimensions, err := parseDimensions("workflow_name,job_name")
+	if err != nil {
+		t.Fatalf("parseDimensions() returned error: %s", err)
+	}
+	original := enabledDimensions
+	enabledDimensions = dimensions
+	defer func() { enabledDimensions = original }()
+
+	jobCounts := newJobCounts()
+	test := createCircleCIJobWithLifeCycle("running")
+	test.Workflows = &circleCiWorkflows{WorkflowName: "build", JobName: "test"}
+	lint := createCircleCIJobWithLifeCycle("running")
+	lint.Workflows = &circleCiWorkflows{WorkflowName: "build", JobName: "lint"}
+	jobCounts.incr(test)
+	jobCounts.incr(lint)
+
+	expectedLen := 2
+	actualLen := len(jobCounts.jobCounts)
+	if actualLen != expectedLen {
+		t.Errorf("incr() result is wrong: expected: %d, actual: %d", expectedLen, actualLen)
+	}
+
+	expectedTags := []string{"vcs_type:github", "username:yuya-takeyama", "reponame:jr", "branch:master", "workflow_name:build", "job_name:test"}
+	actualTags := tagStrings(jobCounts.jobCounts[test.toKey()].toTags())
+	if strings.Join(actualTags, ",") != strings.Join(expectedTags, ",") {
+		t.Errorf("toTags() result is wrong: expected: %v, actual: %v", expectedTags, actualTags)
+	}
+}
+
+func TestJobCountsKeepBranchesWithSlashApart(t *testing.T) {
+	dimensions, err := parseDimensions("workflow_name")
+	if err != nil {
+		t.Fatalf("parseDimensions() returned error: %s", err)
+	}
+	original := enabledDimensions
+	enabledDimensions = dimensions
+	defer func() { enabledDimensions = original }()
+
+	jobCounts := newJobCounts()
+	slashed := createCircleCIJobWithLifeCycle("running")
+	slashed.Branch = "a/b"
+	withWorkflow := createCircleCIJobWithLifeCycle("running")
+	withWorkflow.Branch = "a"
+	withWorkflow.Workflows = &circleCiWorkflows{WorkflowName: "b"}
+	jobCounts.incr(slashed)
+	jobCounts.incr(withWorkflow)
+
+	expectedLen := 2
+	actualLen := len(jobCounts.jobCounts)
+	if actualLen != expectedLen {
+		t.Errorf("incr() result is wrong: expected: %d, actual: %d", expectedLen, actualLen)
+	}
+}
+
+func TestJobCountToTagsSkipsEmptyDimensions(t *testing.T) {
+	dimensions, err := parseDimensions("workflow_name,job_name")
+	if err != nil {
+		t.Fatalf("parseDimensions() returned error: %s", err)
+	}
+	original := enabledDimensions
+	enabledDimensions = dimensions
+	defer func() { enabledDimensions = original }()
+
+	job := createCircleCIJobWithLifeCycle("running")
+	job.Workflows = &circleCiWorkflows{JobName: "test"}
+
+	expectedTags := []string{"vcs_type:github", "username:yuya-takeyama", "reponame:jr", "branch:master", "job_name:test"}
+	actualTags := tagStrings(newJobCount(job).toTags())
+	if strings.Join(actualTags, ",") != strings.Join(expectedTags, ",") {
+		t.Errorf("toTags() result is wrong: expected: %v, actual: %v", expectedTags, actualTags)
+	}
+}
+
+func TestParseDimensionsRejectsUnknown(t *testing.T) {
+	if _, err := parseDimensions("workflow_name,unknown"); err == nil {
+		t.Errorf("parseDimensions() should return error for an unknown dimension")
+	}
+}
+
 func createCircleCIJobWithLifeCycle(lifecycle string) *circleCiJob {
 	return &circleCiJob{
 		VcsType:   "github",
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// finishedBuildRetention is how long finished builds are remembered when
+// --horizon is disabled.
+const finishedBuildRetention = 24 * time.Hour
+
+// durationPercentiles are sent as .p50, .p90 and .p95 in addition to .avg and
+// .max.
+var durationPercentiles = []int{50, 90, 95}
+
+// jobDuration holds wait and run times in seconds of the finished builds of
+// one vcs_type/username/reponame/branch.
+type jobDuration struct {
+	jobCount
+	WaitTimes []float64
+	RunTimes  []float64
+}
+
+type jobDurations struct {
+	jobDurations map[string]*jobDuration
+
+	// builds are the finished builds added, which are marked as counted in
+	// finishedBuilds once their metrics are sent.
+	builds []*circleCiJob
+}
+
+func newJobDurations() *jobDurations {
+	return &jobDurations{
+		jobDurations: make(map[string]*jobDuration),
+	}
+}
+
+func (o *jobDurations) add(job *circleCiJob) {
+	key := job.toKey()
+	jd, ok := o.jobDurations[key]
+	if !ok {
+		jd = &jobDuration{
+			jobCount: *newJobCount(job),
+		}
+		o.jobDurations[key] = jd
+	}
+
+	o.builds = append(o.builds, job)
+	jd.Count++
+	if job.QueuedAt != nil && job.StartTime != nil && !job.StartTime.Before(*job.QueuedAt) {
+		jd.WaitTimes = append(jd.WaitTimes, job.StartTime.Sub(*job.QueuedAt).Seconds())
+	}
+	if job.BuildTimeMillis != nil {
+		jd.RunTimes = append(jd.RunTimes, float64(*job.BuildTimeMillis)/1000)
+	} else if job.StartTime != nil && job.StopTime != nil {
+		jd.RunTimes = append(jd.RunTimes, job.StopTime.Sub(*job.StartTime).Seconds())
+	}
+}
+
+func (o *jobDurations) toMetrics(now time.Time) []sample {
+	var metrics []sample
+
+	for _, jd := range o.jobDurations {
+		tags := jd.toTags()
+		metrics = append(metrics, newSample(now, finishedMetricName, float64(jd.Count), tags))
+		metrics = append(metrics, durationMetrics(now, waitTimeMetricName, jd.WaitTimes, tags)...)
+		metrics = append(metrics, durationMetrics(now, runTimeMetricName, jd.RunTimes, tags)...)
+	}
+
+	return metrics
+}
+
+func durationMetrics(now time.Time, metricName string, durations []float64, tags []tag) []sample {
+	if len(durations) == 0 {
+		return nil
+	}
+
+	sorted := make([]float64, len(durations))
+	copy(sorted, durations)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, d := range sorted {
+		sum += d
+	}
+
+	metrics := []sample{
+		newSample(now, metricName+".avg", sum/float64(len(sorted)), tags),
+		newSample(now, metricName+".max", sorted[len(sorted)-1], tags),
+	}
+	for _, p := range durationPercentiles {
+		metrics = append(metrics, newSample(now, metricName+".p"+strconv.Itoa(p), percentile(sorted, p), tags))
+	}
+
+	return metrics
+}
+
+// percentile returns the p-th percentile of sorted by the nearest-rank method.
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// finishedBuildTracker remembers finished builds across checks, so that each
+// of them is counted in jobDurations exactly once.
+type finishedBuildTracker struct {
+	mu      sync.Mutex
+	since   time.Time
+	counted map[string]time.Time
+}
+
+var finishedBuilds = &finishedBuildTracker{
+	counted: make(map[string]time.Time),
+}
+
+// isNew reports whether the finished build has not been counted yet. Builds
+// which stopped before the first check (minus one interval) are assumed to
+// have been counted by a previous process.
+func (t *finishedBuildTracker) isNew(job *circleCiJob, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.since.IsZero() {
+		t.since = now.Add(-time.Duration(opts.Interval) * time.Second)
+	}
+
+	if job.StopTime.Before(t.since) {
+		return false
+	}
+
+	_, ok := t.counted[job.toBuildKey()]
+
+	return !ok
+}
+
+// markCounted marks the builds as counted. It is called only after their
+// metrics are sent, so that builds of a failed check are counted again by the
+// next one.
+func (t *finishedBuildTracker) markCounted(jobs []*circleCiJob) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, job := range jobs {
+		t.counted[job.toBuildKey()] = *job.StopTime
+	}
+}
+
+// prune forgets builds which stopped before the given time. They are old
+// enough not to be fetched again.
+func (t *finishedBuildTracker) prune(before time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, stopTime := range t.counted {
+		if stopTime.Before(before) {
+			delete(t.counted, key)
+		}
+	}
+	if !t.since.IsZero() && t.since.Before(before) {
+		t.since = before
+	}
+}
+
+func addFinishedJobDurations(jobs []*circleCiJob, durations *jobDurations, tracker *finishedBuildTracker) {
+	now := time.Now()
+
+	for _, job := range jobs {
+		if job.LifeCycle != "finished" || job.StopTime == nil || !isTargetJob(job) {
+			continue
+		}
+		if tracker.isNew(job, now) {
+			durations.add(job)
+		}
+	}
+}
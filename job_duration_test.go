@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobDurationsAdd(t *testing.T) {
+	durations := newJobDurations()
+	queuedAt := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	durations.add(createFinishedJob(1, queuedAt, 30*time.Second, 2*time.Minute))
+	durations.add(createFinishedJob(2, queuedAt, 90*time.Second, time.Minute))
+
+	jd := durations.jobDurations[createCircleCIJobWithLifeCycle("finished").toKey()]
+	if jd == nil {
+		t.Fatalf("add() result is wrong: durations are missing")
+	}
+
+	expectedCount := 2
+	if jd.Count != expectedCount {
+		t.Errorf("add() result is wrong: expected count: %d, actual: %d", expectedCount, jd.Count)
+	}
+
+	expectedWaitTimes := []float64{30, 90}
+	expectedRunTimes := []float64{120, 60}
+	for i := range expectedWaitTimes {
+		if jd.WaitTimes[i] != expectedWaitTimes[i] {
+			t.Errorf("add() result is wrong: expected wait time: %f, actual: %f", expectedWaitTimes[i], jd.WaitTimes[i])
+		}
+		if jd.RunTimes[i] != expectedRunTimes[i] {
+			t.Errorf("add() result is wrong: expected run time: %f, actual: %f", expectedRunTimes[i], jd.RunTimes[i])
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	cases := map[int]float64{50: 5, 90: 9, 95: 10, 100: 10, 0: 1}
+	for p, expected := range cases {
+		actual := percentile(sorted, p)
+		if actual != expected {
+			t.Errorf("percentile(%d) result is wrong: expected: %f, actual: %f", p, expected, actual)
+		}
+	}
+}
+
+func TestFinishedBuildTrackerCountsOnce(t *testing.T) {
+	original := opts
+	opts.Interval = 60
+	defer func() { opts = original }()
+
+	now := time.Date(2018, 10, 1, 1, 0, 0, 0, time.UTC)
+	tracker := &finishedBuildTracker{counted: make(map[string]time.Time)}
+
+	recent := createFinishedJob(1, now.Add(-5*time.Minute), time.Minute, 3*time.Minute)
+	old := createFinishedJob(2, now.Add(-time.Hour), time.Minute, time.Minute)
+
+	if !tracker.isNew(recent, now) {
+		t.Errorf("isNew() should be true for a build stopped within the last interval")
+	}
+	if !tracker.isNew(recent, now.Add(time.Minute)) {
+		t.Errorf("isNew() should be true until the build is marked as counted")
+	}
+	tracker.markCounted([]*circleCiJob{recent})
+	if tracker.isNew(recent, now.Add(time.Minute)) {
+		t.Errorf("isNew() should be false for a build already counted")
+	}
+	if tracker.isNew(old, now) {
+		t.Errorf("isNew() should be false for a build stopped before the first check")
+	}
+
+	tracker.prune(now)
+	if len(tracker.counted) != 0 {
+		t.Errorf("prune() should forget builds stopped before the given time")
+	}
+	if tracker.isNew(recent, now.Add(time.Minute)) {
+		t.Errorf("isNew() should be false for a build stopped before the pruned time")
+	}
+}
+
+func createFinishedJob(buildNum int, queuedAt time.Time, wait, run time.Duration) *circleCiJob {
+	startTime := queuedAt.Add(wait)
+	stopTime := startTime.Add(run)
+	buildTimeMillis := int64(run / time.Millisecond)
+
+	job := createCircleCIJobWithLifeCycle("finished")
+	job.BuildNum = buildNum
+	job.QueuedAt = &queuedAt
+	job.StartTime = &startTime
+	job.StopTime = &stopTime
+	job.BuildTimeMillis = &buildTimeMillis
+
+	return job
+}
@@ -1,53 +1,119 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
-	"github.com/k0kubun/pp"
 	datadog "github.com/zorkian/go-datadog-api"
 )
 
 const appName = "circleci-queue-to-datadog"
 
+// exitCodeFlushFailed is used when the last collected metrics could not be
+// sent, including when the shutdown timed out before sending them.
+const exitCodeFlushFailed = 3
+
 type options struct {
-	Usernames   string `long:"usernames" description:"Comma-separated list of usernames to check queue"`
-	Interval    int    `long:"interval" description:"Interval to check CircleCI queue in seconds" default:"60"`
-	Once        bool   `long:"once" description:"Exits after the first check"`
-	ShowVersion bool   `short:"v" long:"version" description:"Show version"`
+	Usernames            string   `long:"usernames" description:"Comma-separated list of usernames to check queue"`
+	Interval             int      `long:"interval" description:"Interval to check CircleCI queue in seconds" default:"60"`
+	Once                 bool     `long:"once" description:"Exits after the first check"`
+	MaxPages             int      `long:"max-pages" description:"Maximum number of recent-builds (or pipelines) pages to fetch per check" default:"10"`
+	Horizon              int      `long:"horizon" description:"Stop paging once builds queued more than N seconds ago are reached (0 to disable)" default:"3600"`
+	APIVersion           string   `long:"api-version" description:"CircleCI API version to collect queue from" choice:"1.1" choice:"2" default:"1.1"`
+	Dimensions           string   `long:"dimensions" description:"Comma-separated list of optional tags of queue metrics (resource_class, executor, workflow_name, job_name)" default:"resource_class,executor"`
+	Concurrency          string   `long:"concurrency" description:"Concurrency of your plan, as N for every org or comma-separated username=N"`
+	ProjectSlugs         string   `long:"project-slugs" description:"Comma-separated list of project slugs (e.g. gh/org/repo) to check with --api-version=2"`
+	CircleCiHost         string   `long:"circleci-host" description:"Base URL of CircleCI, or of your CircleCI Server installation" default:"https://circleci.com"`
+	HTTPSProxy           string   `long:"https-proxy" description:"Proxy URL for requests to CircleCI (defaults to HTTPS_PROXY environment variable)"`
+	CACert               string   `long:"ca-cert" description:"Path to a PEM bundle of additional CA certificates to trust for CircleCI"`
+	ClientCert           string   `long:"client-cert" description:"Path to a PEM client certificate for CircleCI"`
+	ClientKey            string   `long:"client-key" description:"Path to a PEM private key of --client-cert"`
+	MaxRetries           int      `long:"max-retries" description:"Maximum number of retries of a failed request to CircleCI API" default:"3"`
+	WhenBusy             string   `long:"when-busy" description:"What to do with a check scheduled while the previous one is still running" choice:"skip" choice:"delay" default:"skip"`
+	ShutdownTimeout      int      `long:"shutdown-timeout" description:"Seconds to wait for the running check to send metrics on SIGTERM or SIGINT" default:"10"`
+	ProjectTTL           int      `long:"project-ttl" description:"Keep reporting 0 for a project/branch for N seconds after its last build" default:"86400"`
+	ProjectsFile         string   `long:"projects-file" description:"Path to a file listing vcs_type/username/reponame/branch to always report"`
+	SeedFollowedProjects bool     `long:"seed-followed-projects" description:"Report 0 for the default branch of every project followed by the token owner"`
+	DiscoverProjects     string   `long:"discover-projects" description:"Comma-separated list of VCS types (github, bitbucket) to find projects not followed by the token owner"`
+	AutoFollow           bool     `long:"auto-follow" description:"Follow the projects found by --discover-projects"`
+	Sinks                []string `long:"sink" description:"Where to send metrics (can be given multiple times, defaults to datadog unless --output is given)" choice:"datadog" choice:"statsd" choice:"prometheus" choice:"otlp" choice:"influxdb" choice:"graphite"`
+	StatsdAddress        string   `long:"statsd-address" description:"Address of DogStatsD, as host:port for UDP or unix:///path/to/socket for UDS" default:"127.0.0.1:8125"`
+	StatsdNamespace      string   `long:"statsd-namespace" description:"Prefix of metric names sent to DogStatsD"`
+	StatsdTags           string   `long:"statsd-tags" description:"Comma-separated list of tags added to every metric sent to DogStatsD"`
+	PrometheusAddress    string   `long:"prometheus-address" description:"Address to serve Prometheus metrics on /metrics with --sink=prometheus" default:":9523"`
+	OtlpEndpoint         string   `long:"otlp-endpoint" description:"OTLP/HTTP metrics endpoint with --sink=otlp" default:"http://localhost:4318/v1/metrics"`
+	OtlpHeaders          string   `long:"otlp-headers" description:"Comma-separated list of key=value headers sent to --otlp-endpoint"`
+	OtlpServiceName      string   `long:"otlp-service-name" description:"service.name resource attribute sent with --sink=otlp" default:"circleci-queue-to-datadog"`
+	InfluxDBURL          string   `long:"influxdb-url" description:"Base URL of InfluxDB with --sink=influxdb" default:"http://localhost:8086"`
+	InfluxDBDatabase     string   `long:"influxdb-database" description:"Database to write to in InfluxDB 1.x"`
+	InfluxDBOrg          string   `long:"influxdb-org" description:"Organization to write to in InfluxDB 2.x"`
+	InfluxDBBucket       string   `long:"influxdb-bucket" description:"Bucket to write to in InfluxDB 2.x"`
+	GraphiteAddress      string   `long:"graphite-address" description:"host:port of the plaintext protocol of Carbon with --sink=graphite" default:"localhost:2003"`
+	GraphiteTemplates    []string `long:"graphite-template" description:"Template of Graphite paths as [metric_name=]template, e.g. circleci.queue.{username}.{reponame}.{branch} (can be given multiple times)"`
+	Output               string   `long:"output" description:"Write metrics of every check in the format" choice:"json" choice:"ndjson" choice:"csv"`
+	OutputFile           string   `long:"output-file" description:"Path to write --output to instead of stdout"`
+	OutputMaxSize        int      `long:"output-max-size" description:"Rotate --output-file once it grows past N megabytes (0 to disable)" default:"100"`
+	OutputMaxBackups     int      `long:"output-max-backups" description:"Number of rotated --output-file to keep" default:"5"`
+	DatadogSite          string   `long:"datadog-site" description:"Datadog site to send metrics to, e.g. datadoghq.eu (defaults to DATADOG_HOST environment variable or datadoghq.com)"`
+	DatadogURL           string   `long:"datadog-url" description:"Base URL of the Datadog API, e.g. of a proxy, overriding --datadog-site"`
+	SkipStartupCheck     bool     `long:"skip-startup-check" description:"Start without checking credentials and options as the doctor command does"`
+	ShowVersion          bool     `short:"v" long:"version" description:"Show version"`
 }
 
 var opts options
 var targetUsernames []string
+var targetProjectSlugs []string
 
-var datadogClient = datadog.NewClient(os.Getenv("DATADOG_API_KEY"), "")
+var datadogClient *datadog.Client
 var runningMetricName = "circleci.queue.running"
 var notRunningMetricName = "circleci.queue.not_running"
+var runningContainersMetricName = "circleci.queue.running_containers"
+var notRunningContainersMetricName = "circleci.queue.not_running_containers"
+var lifeCycleMetricName = "circleci.queue.count"
+var statusMetricName = "circleci.queue.status_count"
+var resourceClassRunningMetricName = "circleci.queue.resource_class.running"
+var resourceClassNotRunningMetricName = "circleci.queue.resource_class.not_running"
+var truncatedMetricName = "circleci.queue.truncated"
+var workflowMetricName = "circleci.workflow.count"
+var pipelineMetricName = "circleci.pipeline.count"
+var oldestAgeMetricName = "circleci.queue.oldest_age"
+var orgOldestAgeMetricName = "circleci.queue.org_oldest_age"
+var finishedMetricName = "circleci.build.finished"
+var waitTimeMetricName = "circleci.build.wait_time"
+var runTimeMetricName = "circleci.build.run_time"
+var concurrencyLimitMetricName = "circleci.plan.concurrency"
+var utilizationMetricName = "circleci.plan.utilization"
+var headroomMetricName = "circleci.plan.headroom"
+var saturatedDurationMetricName = "circleci.plan.saturated_duration"
+var pollLagMetricName = "circleci.queue.poll.lag"
+var pollSkippedMetricName = "circleci.queue.poll.skipped"
+var unfollowedMetricName = "circleci.project.unfollowed"
 
-var isDebug = os.Getenv("CIRCLECI_QUEUE_TO_DATADOG_DEBUG") != ""
+var metricsSink Sink
 
-type circleCiJob struct {
-	VcsType   string `json:"vcs_type"`
-	Username  string `json:"username"`
-	Reponame  string `json:"reponame"`
-	Branch    string `json:"branch"`
-	LifeCycle string `json:"lifecycle"`
-}
-
-func (job *circleCiJob) toKey() string {
-	return fmt.Sprintf("%s/%s/%s/%s", job.VcsType, job.Username, job.Reponame, job.Branch)
-}
+var isDebug = os.Getenv("CIRCLECI_QUEUE_TO_DATADOG_DEBUG") != ""
+var debugOutput = &redactingWriter{w: os.Stderr}
 
 func main() {
+	log.SetOutput(&redactingWriter{w: os.Stderr})
+
 	parser := flags.NewParser(&opts, flags.Default^flags.PrintErrors)
 	parser.Name = appName
+	parser.SubcommandsOptional = true
+
+	doctor, err := parser.AddCommand("doctor", "Check credentials and options", "Checks the Datadog API key, the CircleCI API token and the visibility of --usernames, prints a report and exits with non-zero status if any check fails.", &doctorCommand{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	doctor.Aliases = []string{"check-config"}
 
 	if _, err := parser.Parse(); err != nil {
 		if err, ok := err.(*flags.Error); ok && err.Type == flags.ErrHelp {
@@ -67,109 +133,259 @@ func main() {
 		targetUsernames = append(targetUsernames, strings.Split(opts.Usernames, ",")...)
 	}
 
+	dimensions, err := parseDimensions(opts.Dimensions)
+	if err != nil {
+		log.Fatalf("Option error: %s", err)
+	}
+	enabledDimensions = dimensions
+
+	limits, err := parseConcurrencyLimits(opts.Concurrency)
+	if err != nil {
+		log.Fatalf("Option error: %s", err)
+	}
+	planConcurrency = limits
+
+	if len(opts.ProjectSlugs) > 0 {
+		targetProjectSlugs = append(targetProjectSlugs, strings.Split(opts.ProjectSlugs, ",")...)
+	}
+
+	if opts.APIVersion == "2" {
+		if len(targetProjectSlugs) == 0 {
+			log.Fatalf("Option error: --project-slugs is required with --api-version=2")
+		}
+		for _, slug := range targetProjectSlugs {
+			if _, err := parseProjectSlug(slug); err != nil {
+				log.Fatalf("Option error: %s", err)
+			}
+		}
+	}
+
+	if opts.ProjectsFile != "" {
+		projects, err := loadProjectsFile(opts.ProjectsFile)
+		if err != nil {
+			log.Fatalf("Option error: %s", err)
+		}
+		for _, jc := range projects {
+			knownProjects.register(jc, time.Time{})
+		}
+	}
+
+	vcsTypes, err := parseDiscoverVcsTypes(opts.DiscoverProjects)
+	if err != nil {
+		log.Fatalf("Option error: %s", err)
+	}
+	discoverVcsTypes = vcsTypes
+
+	if err := configureCircleCi(); err != nil {
+		log.Fatalf("Option error: %s", err)
+	}
+
+	if opts.MaxPages < 1 {
+		log.Fatalf("Option error: --max-pages must be greater than 0")
+	}
+
+	client, err := newDatadogClient(os.Getenv("DATADOG_API_KEY"), opts.DatadogSite, opts.DatadogURL)
+	if err != nil {
+		log.Fatalf("Option error: %s", err)
+	}
+	datadogClient = client
+
+	if parser.Active != nil {
+		if !runDoctor(context.Background(), os.Stdout, true) {
+			os.Exit(exitCodeCheckFailed)
+		}
+		return
+	}
+
+	if !opts.SkipStartupCheck && !runDoctor(context.Background(), logWriter{}, false) {
+		os.Exit(exitCodeCheckFailed)
+	}
+
+	sink, err := newMetricsSink()
+	if err != nil {
+		log.Fatalf("Option error: %s", err)
+	}
+	metricsSink = sink
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cancelOnSignal(cancel)
+
 	if opts.Once {
 		if opts.Interval > 0 {
 			log.Println("--interval has no effect with --once mode")
 		}
 
-		getAndSendMetrics()
+		if err := getAndSendMetrics(ctx, time.Now(), 0); err != nil {
+			os.Exit(exitCodeFlushFailed)
+		}
 		return
 	}
 
-	for {
-		go getAndSendMetrics()
-		time.Sleep(time.Duration(opts.Interval) * time.Second)
+	if opts.Interval < 1 {
+		log.Fatalf("Option error: --interval must be greater than 0")
+	}
+
+	interval := time.Duration(opts.Interval) * time.Second
+	s := newScheduler(interval, opts.WhenBusy == "delay", getAndSendMetrics)
+	s.run(ctx)
+
+	if err := s.wait(time.Duration(opts.ShutdownTimeout) * time.Second); err != nil {
+		log.Printf("failed to send the last metrics before shutdown: %s", redactError(err))
+		os.Exit(exitCodeFlushFailed)
 	}
+	log.Println("shut down gracefully")
+}
+
+// cancelOnSignal cancels the running check on SIGTERM or SIGINT. Requests to
+// CircleCI are canceled, but metrics already collected are still sent.
+func cancelOnSignal(cancel context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+		log.Printf("received %s, shutting down", sig)
+		cancel()
+	}()
 }
 
-func getAndSendMetrics() {
+func getAndSendMetrics(ctx context.Context, scheduledAt time.Time, skipped int) error {
 	now := time.Now()
-	if runningCounts, notRunningCounts, err := getJobCounts(); err != nil {
-		log.Println(err)
-	} else {
-		log.Printf("running:%d\tnot_running:%d", runningCounts.getTotalCount(), notRunningCounts.getTotalCount())
-
-		runningMetrics := runningCounts.toMetrics(now, runningMetricName)
-		notRunningMetrics := notRunningCounts.toMetrics(now, notRunningMetricName)
-		metrics := append(runningMetrics, notRunningMetrics...)
-
-		if isDebug {
-			fmt.Fprintln(os.Stderr, "Running:")
-			pp.Fprintln(os.Stderr, runningMetrics)
-			fmt.Fprintln(os.Stderr, "Not Running:")
-			pp.Fprintln(os.Stderr, notRunningMetrics)
-		} else {
-			if err := datadogClient.PostMetrics(metrics); err != nil {
-				log.Printf("failed to post metrics to Datadog: %s", err)
-			} else {
-				log.Printf("successfully sent metrics at %s to Datadog!", now.Format(time.RFC3339))
-			}
-		}
+	lag := now.Sub(scheduledAt)
+	discoveryCtx := ctx
+
+	// Give up retrying before the next check starts.
+	if opts.Interval > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, scheduledAt.Add(time.Duration(opts.Interval)*time.Second))
+		defer cancel()
 	}
-}
 
-func getJobCounts() (*jobCounts, *jobCounts, error) {
-	runningCounts := newJobCounts()
-	notRunningCounts := newJobCounts()
+	stats, err := collectQueueStats(ctx)
+	if err != nil {
+		log.Println(redactError(err))
+		polls.record(now, true)
+		return nil
+	}
+
+	updateKnownProjects(ctx, stats, now)
 
-	req, reqErr := http.NewRequest("GET", "https://circleci.com/api/v1.1/recent-builds?limit=100&circle-token="+os.Getenv("CIRCLECI_API_TOKEN"), nil)
-	if reqErr != nil {
-		return runningCounts, notRunningCounts, fmt.Errorf("failed to build HTTP request to CircleCI API: %s", reqErr)
+	// With --once, there is no next check to report the result of discovery
+	// from, so it runs before the metrics are built.
+	if opts.Once {
+		discoverProjects(discoveryCtx, now)
 	}
 
-	req.Header.Add("Accept", "application/json")
-	res, resErr := http.DefaultClient.Do(req)
-	if resErr != nil {
-		return runningCounts, notRunningCounts, fmt.Errorf("failed to get recent builds from CircleCI API: %s", resErr)
+	log.Printf("running:%d\tnot_running:%d", stats.runningCounts.getTotalCount(), stats.notRunningCounts.getTotalCount())
+
+	if stats.truncated {
+		log.Printf("recent builds were truncated at %d pages before reaching the horizon; consider increasing --max-pages", opts.MaxPages)
 	}
 
-	defer res.Body.Close()
+	metrics := stats.toMetrics(now)
+	metrics = append(metrics,
+		newSample(now, pollLagMetricName, lag.Seconds(), nil),
+		newSample(now, pollSkippedMetricName, float64(skipped), nil),
+	)
+	metrics = append(metrics, concurrencyMetrics(now, stats.runningContainerCounts, planConcurrency, planSaturation)...)
+	metrics = append(metrics, projectAudit.toMetrics(now)...)
 
-	d := json.NewDecoder(res.Body)
-	for {
-		var jobs []*circleCiJob
-		if err := d.Decode(&jobs); err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return runningCounts, notRunningCounts, fmt.Errorf("failed to parse response from CircleCI API: %s", err)
-			}
-		}
+	// Metrics already collected are sent even after ctx is canceled on
+	// shutdown.
+	err = metricsSink.Send(context.Background(), metrics)
+	polls.record(now, err != nil)
+	if err == nil {
+		finishedBuilds.markCounted(stats.jobDurations.builds)
+	}
 
-		incrJobCounts(jobs, runningCounts, notRunningCounts)
+	// Discovery takes many requests, so it runs after sending without
+	// delaying the next check, and its result is reported from then on.
+	if !opts.Once {
+		go discoverProjects(discoveryCtx, now)
 	}
 
-	return runningCounts, notRunningCounts, nil
+	return err
 }
 
-func incrJobCounts(jobs []*circleCiJob, runningCounts, notRunningCounts *jobCounts) (*jobCounts, *jobCounts) {
-	for _, job := range jobs {
-		if isTargetJob(job) {
-			if job.LifeCycle == "running" {
-				runningCounts.incr(job)
-				notRunningCounts.ensure(job)
-			} else if job.LifeCycle == "not_running" {
-				runningCounts.ensure(job)
-				notRunningCounts.incr(job)
-			} else {
-				runningCounts.ensure(job)
-				notRunningCounts.ensure(job)
-			}
-		}
+// sinkNames returns the names of --sink, which defaults to datadog unless
+// --output is given.
+func sinkNames() []string {
+	if len(opts.Sinks) == 0 && opts.Output == "" {
+		return []string{"datadog"}
 	}
 
-	return runningCounts, notRunningCounts
+	return opts.Sinks
 }
 
-func isTargetJob(job *circleCiJob) bool {
-	if len(targetUsernames) == 0 {
-		return true
+// newMetricsSink returns the sink which every check sends metrics to,
+// fanning out to every --sink.
+func newMetricsSink() (Sink, error) {
+	if isDebug {
+		return newFanOutSink(newOutputSink("ndjson", debugOutput)), nil
 	}
-	for _, targetUsername := range targetUsernames {
-		if job.Username == targetUsername {
-			return true
+
+	var sinks []Sink
+	if opts.Output != "" {
+		var w io.Writer = os.Stdout
+		if opts.OutputFile != "" {
+			f, err := newRotatingFile(opts.OutputFile, int64(opts.OutputMaxSize)*1024*1024, opts.OutputMaxBackups)
+			if err != nil {
+				return nil, err
+			}
+			w = f
 		}
+		sinks = append(sinks, newOutputSink(opts.Output, w))
+	}
+
+	for _, name := range sinkNames() {
+		switch name {
+		case "datadog":
+			sinks = append(sinks, newDatadogSink(datadogClient))
+		case "prometheus":
+			s := newPrometheusSink()
+			if err := servePrometheus(opts.PrometheusAddress, s); err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		case "otlp":
+			s, err := newOtlpSink(opts.OtlpEndpoint, opts.OtlpHeaders, opts.OtlpServiceName)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		case "influxdb":
+			s, err := newInfluxDBSink(opts.InfluxDBURL, opts.InfluxDBDatabase, opts.InfluxDBOrg, opts.InfluxDBBucket)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		case "graphite":
+			templates, err := parseGraphiteTemplates(opts.GraphiteTemplates)
+			if err != nil {
+				return nil, err
+			}
+			s, err := newGraphiteSink(opts.GraphiteAddress, templates)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		case "statsd":
+			s, err := newStatsdSink(opts.StatsdAddress, opts.StatsdNamespace, parseStatsdTags(opts.StatsdTags))
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		}
+	}
+
+	return newFanOutSink(sinks...), nil
+}
+
+func collectQueueStats(ctx context.Context) (*queueStats, error) {
+	if opts.APIVersion == "2" {
+		return getPipelineJobCounts(ctx)
 	}
 
-	return false
+	return getJobCounts(ctx)
 }
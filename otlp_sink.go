@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const otlpTimeout = 30 * time.Second
+
+// otlpSink exports samples as OTLP gauges over HTTP with the JSON encoding,
+// which any OpenTelemetry Collector accepts on /v1/metrics. gRPC is not
+// supported to avoid depending on gRPC and protobuf.
+type otlpSink struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+}
+
+func newOtlpSink(endpoint, headers, serviceName string) (*otlpSink, error) {
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid --otlp-endpoint: %s", err)
+	}
+
+	parsedHeaders, err := parseOtlpHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &otlpSink{
+		endpoint:    endpoint,
+		headers:     parsedHeaders,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpTimeout},
+	}, nil
+}
+
+// parseOtlpHeaders parses the comma-separated key=value headers of
+// --otlp-headers, as OTEL_EXPORTER_OTLP_HEADERS does.
+func parseOtlpHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	if s == "" {
+		return headers, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header in --otlp-headers: %s", entry)
+		}
+		key, err := url.QueryUnescape(strings.TrimSpace(entry[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header in --otlp-headers: %s", entry)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(entry[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header in --otlp-headers: %s", entry)
+		}
+		headers[key] = value
+	}
+
+	return headers, nil
+}
+
+func (s *otlpSink) Name() string {
+	return "OTLP"
+}
+
+func (s *otlpSink) Send(ctx context.Context, samples []sample) error {
+	body, err := json.Marshal(s.toRequest(samples))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("OTLP endpoint returned %s", res.Status)
+	}
+
+	return nil
+}
+
+// The types below are the subset of the JSON encoding of
+// ExportMetricsServiceRequest used to send gauges.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Gauge otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+	// TimeUnixNano is a fixed64, which is encoded as a string in JSON.
+	TimeUnixNano string  `json:"timeUnixNano"`
+	AsDouble     float64 `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func newOtlpAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpAttributeValue{StringValue: value}}
+}
+
+// toRequest groups samples into one gauge per metric name, with tags as
+// data point attributes.
+func (s *otlpSink) toRequest(samples []sample) *otlpRequest {
+	var metrics []otlpMetric
+	indexes := make(map[string]int)
+
+	for _, m := range samples {
+		i, ok := indexes[m.Name]
+		if !ok {
+			i = len(metrics)
+			indexes[m.Name] = i
+			metrics = append(metrics, otlpMetric{Name: m.Name})
+		}
+
+		attributes := make([]otlpAttribute, len(m.Tags))
+		for j, t := range m.Tags {
+			attributes[j] = newOtlpAttribute(t.Key, t.Value)
+		}
+		metrics[i].Gauge.DataPoints = append(metrics[i].Gauge.DataPoints, otlpDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: strconv.FormatInt(m.Timestamp.UnixNano(), 10),
+			AsDouble:     m.Value,
+		})
+	}
+
+	return &otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{
+					newOtlpAttribute("service.name", s.serviceName),
+					newOtlpAttribute("service.version", version),
+				},
+			},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: appName, Version: version},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
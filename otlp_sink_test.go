@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOtlpSinkExportsGauges(t *testing.T) {
+	var received otlpRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("header is wrong: %q", r.Header.Get("Authorization"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode request: %s", err)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer collector.Close()
+
+	sink, err := newOtlpSink(collector.URL+"/v1/metrics", "Authorization=Bearer%20secret", "ci-queue")
+	if err != nil {
+		t.Fatalf("newOtlpSink() returned error: %s", err)
+	}
+
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	samples := []sample{
+		newSample(now, runningMetricName, 1, []tag{{"branch", "master"}}),
+		newSample(now, runningMetricName, 2, []tag{{"branch", "feature"}}),
+		newSample(now, truncatedMetricName, 0, nil),
+	}
+	if err := sink.Send(context.Background(), samples); err != nil {
+		t.Fatalf("Send() returned error: %s", err)
+	}
+
+	if len(received.ResourceMetrics) != 1 {
+		t.Fatalf("resource metrics are wrong: %v", received)
+	}
+	resource := received.ResourceMetrics[0].Resource.Attributes
+	if len(resource) != 2 || resource[0].Value.StringValue != "ci-queue" || resource[1].Value.StringValue != version {
+		t.Errorf("resource attributes are wrong: %v", resource)
+	}
+
+	metrics := received.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(metrics) != 2 || metrics[0].Name != runningMetricName || len(metrics[0].Gauge.DataPoints) != 2 {
+		t.Fatalf("metrics are wrong: %v", metrics)
+	}
+	point := metrics[0].Gauge.DataPoints[1]
+	if point.AsDouble != 2 || point.TimeUnixNano != "1538352000000000000" || point.Attributes[0].Value.StringValue != "feature" {
+		t.Errorf("data point is wrong: %v", point)
+	}
+}
+
+func TestOtlpSinkReturnsErrorOnRejection(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	sink, err := newOtlpSink(collector.URL, "", "ci-queue")
+	if err != nil {
+		t.Fatalf("newOtlpSink() returned error: %s", err)
+	}
+
+	if err := sink.Send(context.Background(), nil); err == nil {
+		t.Errorf("Send() should return error")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputRecord is written for each series on every check.
+type outputRecord struct {
+	Timestamp string            `json:"timestamp"`
+	Name      string            `json:"name"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+var csvHeader = []string{"timestamp", "name", "value", "tags"}
+
+// outputSink writes samples to w as a JSON array per check (json), a JSON
+// object per line (ndjson), or CSV with a header (csv). The samples of a check
+// are written in a single Write, so that a rotated file never splits them.
+type outputSink struct {
+	format string
+
+	mu sync.Mutex
+	w  io.Writer
+	// headerWritten is false until the CSV header is written to w. Rotated
+	// files get their own header from rotatingFile.
+	headerWritten bool
+}
+
+func newOutputSink(format string, w io.Writer) *outputSink {
+	s := &outputSink{format: format, w: w}
+	if f, ok := w.(*rotatingFile); ok && format == "csv" {
+		f.header = formatCSV(nil, true)
+		s.headerWritten = true
+	}
+
+	return s
+}
+
+func (s *outputSink) Name() string {
+	return s.format + " output"
+}
+
+func (s *outputSink) Send(ctx context.Context, samples []sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []byte
+	switch s.format {
+	case "json":
+		records := make([]*outputRecord, len(samples))
+		for i, m := range samples {
+			records[i] = newOutputRecord(m)
+		}
+		b, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		out = append(b, '\n')
+	case "ndjson":
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, m := range samples {
+			if err := enc.Encode(newOutputRecord(m)); err != nil {
+				return err
+			}
+		}
+		out = buf.Bytes()
+	case "csv":
+		out = formatCSV(samples, !s.headerWritten)
+		s.headerWritten = true
+	default:
+		return fmt.Errorf("unknown output format: %s", s.format)
+	}
+
+	_, err := s.w.Write(out)
+
+	return err
+}
+
+func newOutputRecord(m sample) *outputRecord {
+	tags := make(map[string]string, len(m.Tags))
+	for _, t := range m.Tags {
+		tags[t.Key] = t.Value
+	}
+
+	return &outputRecord{
+		Timestamp: m.Timestamp.Format(time.RFC3339),
+		Name:      m.Name,
+		Value:     m.Value,
+		Tags:      tags,
+	}
+}
+
+// formatCSV formats samples with tags joined as key:value separated by
+// spaces, the same as Datadog tags.
+func formatCSV(samples []sample, withHeader bool) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if withHeader {
+		w.Write(csvHeader)
+	}
+	for _, m := range samples {
+		w.Write([]string{
+			m.Timestamp.Format(time.RFC3339),
+			m.Name,
+			strconv.FormatFloat(m.Value, 'f', -1, 64),
+			strings.Join(tagStrings(m.Tags), " "),
+		})
+	}
+	w.Flush()
+
+	return buf.Bytes()
+}
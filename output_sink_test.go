@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputSinkWritesNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	sink := newOutputSink("ndjson", &buf)
+
+	if err := sink.Send(context.Background(), createOutputSamples()); err != nil {
+		t.Fatalf("Send() returned error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("one line should be written per series: %q", buf.String())
+	}
+
+	var record outputRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("failed to parse line: %s", err)
+	}
+	if record.Name != runningMetricName || record.Value != 1 || record.Tags["branch"] != "master" || record.Timestamp != "2018-10-01T00:00:00Z" {
+		t.Errorf("record is wrong: %v", record)
+	}
+}
+
+func TestOutputSinkWritesJSON(t *testing.T) {
+	var buf bytes.Buffer
+	sink := newOutputSink("json", &buf)
+
+	sink.Send(context.Background(), createOutputSamples())
+	sink.Send(context.Background(), createOutputSamples())
+
+	dec := json.NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		var records []outputRecord
+		if err := dec.Decode(&records); err != nil {
+			t.Fatalf("failed to parse check %d: %s", i, err)
+		}
+		if len(records) != 2 {
+			t.Errorf("records are wrong: %v", records)
+		}
+	}
+}
+
+func TestOutputSinkWritesCSV(t *testing.T) {
+	var buf bytes.Buffer
+	sink := newOutputSink("csv", &buf)
+
+	sink.Send(context.Background(), createOutputSamples())
+	sink.Send(context.Background(), createOutputSamples()[:1])
+
+	expected := `timestamp,name,value,tags
+2018-10-01T00:00:00Z,circleci.queue.running,1,branch:master resource_class:medium
+2018-10-01T00:00:00Z,circleci.queue.truncated,0,
+2018-10-01T00:00:00Z,circleci.queue.running,1,branch:master resource_class:medium
+`
+	if buf.String() != expected {
+		t.Errorf("CSV is wrong: expected: %q, actual: %q", expected, buf.String())
+	}
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "metrics.csv")
+	f, err := newRotatingFile(path, 150, 2)
+	if err != nil {
+		t.Fatalf("newRotatingFile() returned error: %s", err)
+	}
+	sink := newOutputSink("csv", f)
+
+	for i := 0; i < 4; i++ {
+		if err := sink.Send(context.Background(), createOutputSamples()[:1]); err != nil {
+			t.Fatalf("Send() returned error: %s", err)
+		}
+	}
+
+	for _, name := range []string{"metrics.csv", "metrics.csv.1", "metrics.csv.2"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", name, err)
+		}
+		if !strings.HasPrefix(string(content), "timestamp,name,value,tags\n") {
+			t.Errorf("%s should start with the header: %q", name, content)
+		}
+		if len(content) > 150 {
+			t.Errorf("%s is too large: %d bytes", name, len(content))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "metrics.csv.3")); !os.IsNotExist(err) {
+		t.Errorf("only 2 rotated files should be kept")
+	}
+}
+
+func createOutputSamples() []sample {
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	return []sample{
+		newSample(now, runningMetricName, 1, []tag{{"branch", "master"}, {"resource_class", "medium"}}),
+		newSample(now, truncatedMetricName, 0, nil),
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// projectDiscoveryInterval is how often the projects of the orgs are listed
+// with --discover-projects.
+const projectDiscoveryInterval = time.Hour
+
+// projectDiscoveryTimeout bounds a discovery, which runs apart from the
+// checks and their deadlines.
+const projectDiscoveryTimeout = 10 * time.Minute
+
+// projectDiscoveryMaxPages caps the repository pages listed per VCS type.
+const projectDiscoveryMaxPages = 50
+
+// circleCiRepo is a repository visible to the owner of the token. It is built
+// on CircleCI if anyone follows it.
+type circleCiRepo struct {
+	Username     string `json:"username"`
+	Name         string `json:"name"`
+	HasFollowers bool   `json:"has_followers"`
+}
+
+// projectDiscovery remembers the result of the last discovery, so that it is
+// reported on every check in between.
+type projectDiscovery struct {
+	mu           sync.Mutex
+	discoveredAt time.Time
+	running      bool
+
+	// unfollowed holds the reponames of unfollowed projects of each
+	// vcs_type/username.
+	unfollowed map[string][]string
+}
+
+var projectAudit = &projectDiscovery{unfollowed: make(map[string][]string)}
+
+// discoverVcsTypes are the VCS types given with --discover-projects.
+var discoverVcsTypes []string
+
+// parseDiscoverVcsTypes parses the comma-separated VCS types of
+// --discover-projects.
+func parseDiscoverVcsTypes(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var vcsTypes []string
+	for _, vcsType := range strings.Split(s, ",") {
+		if vcsType != "github" && vcsType != "bitbucket" {
+			return nil, fmt.Errorf("unknown VCS type in --discover-projects: %s", vcsType)
+		}
+		vcsTypes = append(vcsTypes, vcsType)
+	}
+
+	return vcsTypes, nil
+}
+
+// discoverProjects lists the projects of the target orgs built on CircleCI
+// once every projectDiscoveryInterval, and finds the ones the owner of the
+// token does not follow, which are missing from recent builds. With
+// --auto-follow, they are followed. A failed discovery is retried on the next
+// check.
+func discoverProjects(ctx context.Context, now time.Time) {
+	if len(discoverVcsTypes) == 0 || !projectAudit.isDue(now) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, projectDiscoveryTimeout)
+	defer cancel()
+
+	unfollowed, err := listUnfollowedProjects(ctx)
+	if err != nil {
+		log.Println(redactError(err))
+	}
+	projectAudit.finish(now, unfollowed, err)
+}
+
+// listUnfollowedProjects returns the reponames of the projects not followed
+// by the owner of the token for each vcs_type/username. Recent builds only
+// contain followed projects, so the followed ones are all that is seen.
+func listUnfollowedProjects(ctx context.Context) (map[string][]string, error) {
+	followed, err := listFollowedProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range followed {
+		seen[p.VcsType+"/"+p.Username+"/"+p.Reponame] = true
+	}
+
+	unfollowed := make(map[string][]string)
+	for _, vcsType := range discoverVcsTypes {
+		repos, err := listRepos(ctx, vcsType)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, repo := range repos {
+			if !isTargetUsername(repo.Username) {
+				continue
+			}
+
+			org := vcsType + "/" + repo.Username
+			if _, ok := unfollowed[org]; !ok {
+				unfollowed[org] = []string{}
+			}
+			if !repo.HasFollowers || seen[org+"/"+repo.Name] {
+				continue
+			}
+
+			if opts.AutoFollow {
+				if err := followProject(ctx, vcsType, repo.Username, repo.Name); err != nil {
+					log.Println(redactError(err))
+				} else {
+					log.Printf("followed %s/%s", org, repo.Name)
+					continue
+				}
+			}
+			unfollowed[org] = append(unfollowed[org], repo.Name)
+		}
+	}
+
+	for org, reponames := range unfollowed {
+		if len(reponames) > 0 {
+			sort.Strings(reponames)
+			log.Printf("projects of %s not followed by the owner of the token are missing from the metrics: %s", org, strings.Join(reponames, ", "))
+		}
+	}
+
+	return unfollowed, nil
+}
+
+// isDue reports whether the projects should be discovered again, and if so,
+// marks a discovery as running until finish is called.
+func (d *projectDiscovery) isDue(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.running || !d.discoveredAt.IsZero() && now.Sub(d.discoveredAt) < projectDiscoveryInterval {
+		return false
+	}
+	d.running = true
+
+	return true
+}
+
+// finish keeps the result of the discovery started at now, unless it failed.
+func (d *projectDiscovery) finish(now time.Time, unfollowed map[string][]string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.running = false
+	if err != nil {
+		return
+	}
+	d.discoveredAt = now
+	d.unfollowed = unfollowed
+}
+
+func (d *projectDiscovery) toMetrics(now time.Time) []sample {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var metrics []sample
+	for org, reponames := range d.unfollowed {
+		parts := strings.SplitN(org, "/", 2)
+		tags := []tag{{"vcs_type", parts[0]}, {"username", parts[1]}}
+		metrics = append(metrics, newSample(now, unfollowedMetricName, float64(len(reponames)), tags))
+	}
+
+	return metrics
+}
+
+// listRepos lists the repositories of every org visible to the owner of the
+// token.
+func listRepos(ctx context.Context, vcsType string) ([]*circleCiRepo, error) {
+	var repos []*circleCiRepo
+	for page := 1; page <= projectDiscoveryMaxPages; page++ {
+		var pageRepos []*circleCiRepo
+		u := circleCiAPIBaseURL + "/user/repos/" + vcsType + "?page=" + strconv.Itoa(page)
+		if err := getCircleCiJSON(ctx, "repositories", u, &pageRepos); err != nil {
+			return nil, err
+		}
+		if len(pageRepos) == 0 {
+			break
+		}
+		repos = append(repos, pageRepos...)
+	}
+
+	return repos, nil
+}
+
+func followProject(ctx context.Context, vcsType, username, reponame string) error {
+	u := circleCiAPIBaseURL + "/project/" + vcsType + "/" + url.PathEscape(username) + "/" + url.PathEscape(reponame) + "/follow"
+
+	return postCircleCi(ctx, "follow "+vcsType+"/"+username+"/"+reponame, u)
+}
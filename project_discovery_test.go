@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiscoverProjects(t *testing.T) {
+	follows := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/projects":
+			fmt.Fprint(w, `[{"vcs_type": "github", "username": "yuya-takeyama", "reponame": "followed"}, {"vcs_type": "github", "username": "yuya-takeyama", "reponame": "jr"}]`)
+		case r.URL.Path == "/user/repos/github" && r.URL.Query().Get("page") == "1":
+			fmt.Fprint(w, `[
+				{"username": "yuya-takeyama", "name": "followed", "has_followers": true},
+				{"username": "yuya-takeyama", "name": "jr", "has_followers": true},
+				{"username": "yuya-takeyama", "name": "unfollowed", "has_followers": true},
+				{"username": "yuya-takeyama", "name": "not-built", "has_followers": false}
+			]`)
+		case r.URL.Path == "/user/repos/github":
+			fmt.Fprint(w, `[]`)
+		case r.Method == "POST" && r.URL.Path == "/project/github/yuya-takeyama/unfollowed/follow":
+			follows++
+			fmt.Fprint(w, `{"following": true}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	originalBaseURL := circleCiAPIBaseURL
+	originalVcsTypes := discoverVcsTypes
+	originalAudit := projectAudit
+	originalOpts := opts
+	circleCiAPIBaseURL = server.URL
+	discoverVcsTypes = []string{"github"}
+	defer func() {
+		circleCiAPIBaseURL = originalBaseURL
+		discoverVcsTypes = originalVcsTypes
+		projectAudit = originalAudit
+		opts = originalOpts
+	}()
+
+	now := time.Now()
+
+	projectAudit = &projectDiscovery{unfollowed: make(map[string][]string)}
+	discoverProjects(context.Background(), now)
+
+	unfollowed := projectAudit.unfollowed["github/yuya-takeyama"]
+	if len(unfollowed) != 1 || unfollowed[0] != "unfollowed" {
+		t.Errorf("unfollowed projects are wrong: %v", unfollowed)
+	}
+	metrics := projectAudit.toMetrics(now)
+	if len(metrics) != 1 || metrics[0].Value != 1 {
+		t.Errorf("unfollowed metric is wrong: %v", metrics)
+	}
+
+	opts.AutoFollow = true
+	projectAudit = &projectDiscovery{unfollowed: make(map[string][]string)}
+	discoverProjects(context.Background(), now)
+
+	if follows != 1 {
+		t.Errorf("number of follows is wrong: expected: %d, actual: %d", 1, follows)
+	}
+	if unfollowed := projectAudit.unfollowed["github/yuya-takeyama"]; len(unfollowed) != 0 {
+		t.Errorf("followed project should not be reported: %v", unfollowed)
+	}
+}
+
+func TestDiscoverProjectsRetriesAfterFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	originalBaseURL := circleCiAPIBaseURL
+	originalVcsTypes := discoverVcsTypes
+	originalAudit := projectAudit
+	circleCiAPIBaseURL = server.URL
+	discoverVcsTypes = []string{"github"}
+	defer func() {
+		circleCiAPIBaseURL = originalBaseURL
+		discoverVcsTypes = originalVcsTypes
+		projectAudit = originalAudit
+	}()
+
+	now := time.Now()
+	projectAudit = &projectDiscovery{unfollowed: make(map[string][]string)}
+	discoverProjects(context.Background(), now)
+
+	if !projectAudit.isDue(now.Add(time.Minute)) {
+		t.Errorf("failed discovery should be retried on the next check")
+	}
+}
+
+func TestParseDiscoverVcsTypes(t *testing.T) {
+	vcsTypes, err := parseDiscoverVcsTypes("github,bitbucket")
+	if err != nil || len(vcsTypes) != 2 {
+		t.Errorf("parseDiscoverVcsTypes() result is wrong: %v, %v", vcsTypes, err)
+	}
+
+	if _, err := parseDiscoverVcsTypes("gitlab"); err == nil {
+		t.Errorf("parseDiscoverVcsTypes() should return error")
+	}
+}
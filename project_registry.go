@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// followedProjectsRefreshInterval is how often the followed projects are
+// fetched again with --seed-followed-projects.
+const followedProjectsRefreshInterval = time.Hour
+
+// projectRegistry remembers every project/branch seen in builds, listed in
+// --projects-file or followed by the token owner, so that their counts keep
+// being reported as 0 after they drop out of recent builds.
+type projectRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*registeredProject
+
+	// followedAt is when the followed projects were fetched last time.
+	followedAt time.Time
+}
+
+// registeredProject is forgotten at expiresAt, or never if it is zero.
+type registeredProject struct {
+	jobCount  jobCount
+	expiresAt time.Time
+}
+
+var knownProjects = newProjectRegistry()
+
+func newProjectRegistry() *projectRegistry {
+	return &projectRegistry{
+		entries: make(map[string]*registeredProject),
+	}
+}
+
+// register adds jc, or extends its expiry. Projects registered without expiry
+// are never forgotten.
+func (r *projectRegistry) register(jc *jobCount, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := jc.toKey()
+	if entry, ok := r.entries[key]; ok {
+		if !entry.expiresAt.IsZero() && (expiresAt.IsZero() || expiresAt.After(entry.expiresAt)) {
+			entry.expiresAt = expiresAt
+		}
+		return
+	}
+
+	entry := &registeredProject{jobCount: *jc, expiresAt: expiresAt}
+	entry.jobCount.Count = 0
+	r.entries[key] = entry
+}
+
+// observe registers every project/branch counted in stats until now+ttl.
+func (r *projectRegistry) observe(stats *queueStats, now time.Time, ttl time.Duration) {
+	for _, jc := range stats.runningCounts.jobCounts {
+		r.register(jc, now.Add(ttl))
+	}
+}
+
+// expire forgets the projects which expired by now.
+func (r *projectRegistry) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, entry := range r.entries {
+		if !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+			delete(r.entries, key)
+		}
+	}
+}
+
+// ensureZeros adds zero counts of every registered project to stats. Seeds
+// have no dimension values, so they are reported only when no series of the
+// same project/branch is, rather than next to the series of its builds.
+func (r *projectRegistry) ensureZeros(stats *queueStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var seeds []*jobCount
+	for _, entry := range r.entries {
+		if !isTargetUsername(entry.jobCount.Username) {
+			continue
+		}
+		if len(entry.jobCount.Dimensions) == 0 {
+			seeds = append(seeds, &entry.jobCount)
+			continue
+		}
+		stats.ensureZero(&entry.jobCount)
+	}
+
+	reported := make(map[string]bool)
+	for _, jc := range stats.runningCounts.jobCounts {
+		reported[jc.toProjectKey()] = true
+	}
+	for _, jc := range seeds {
+		if !reported[jc.toProjectKey()] {
+			stats.ensureZero(jc)
+		}
+	}
+}
+
+// needsFollowedProjects reports whether the followed projects should be
+// fetched again.
+func (r *projectRegistry) needsFollowedProjects(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.followedAt.IsZero() || now.Sub(r.followedAt) >= followedProjectsRefreshInterval
+}
+
+// markFollowedProjects marks the followed projects as fetched at now. It is
+// called only after a successful fetch, so that a failed one is retried on
+// the next check.
+func (r *projectRegistry) markFollowedProjects(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.followedAt = now
+}
+
+// updateKnownProjects registers the projects in stats and adds zero counts of
+// the other known projects to it.
+func updateKnownProjects(ctx context.Context, stats *queueStats, now time.Time) {
+	ttl := time.Duration(opts.ProjectTTL) * time.Second
+
+	if opts.SeedFollowedProjects && knownProjects.needsFollowedProjects(now) {
+		projects, err := getFollowedProjects(ctx)
+		if err != nil {
+			log.Println(redactError(err))
+		} else {
+			knownProjects.markFollowedProjects(now)
+		}
+		for _, jc := range projects {
+			// Followed projects are registered again on every refresh, so they
+			// expire only after being unfollowed.
+			knownProjects.register(jc, now.Add(ttl+followedProjectsRefreshInterval))
+		}
+	}
+
+	knownProjects.observe(stats, now, ttl)
+	knownProjects.expire(now)
+	knownProjects.ensureZeros(stats)
+}
+
+// newSeedJobCount returns a project/branch to report before its first build.
+// It has no dimension values, which are unknown until then.
+func newSeedJobCount(vcsType, username, reponame, branch string) *jobCount {
+	return &jobCount{
+		VcsType:  vcsType,
+		Username: username,
+		Reponame: reponame,
+		Branch:   branch,
+	}
+}
+
+type circleCiProject struct {
+	VcsType       string `json:"vcs_type"`
+	Username      string `json:"username"`
+	Reponame      string `json:"reponame"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+// listFollowedProjects returns every project followed by the owner of the
+// token. Only their builds appear in recent builds.
+func listFollowedProjects(ctx context.Context) ([]*circleCiProject, error) {
+	var projects []*circleCiProject
+	if err := getCircleCiJSON(ctx, "followed projects", circleCiAPIBaseURL+"/projects", &projects); err != nil {
+		return nil, err
+	}
+
+	return projects, nil
+}
+
+// getFollowedProjects returns the default branch of every project followed by
+// the owner of the token.
+func getFollowedProjects(ctx context.Context) ([]*jobCount, error) {
+	projects, err := listFollowedProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobCounts []*jobCount
+	for _, p := range projects {
+		if p.DefaultBranch == "" {
+			continue
+		}
+		jobCounts = append(jobCounts, newSeedJobCount(p.VcsType, p.Username, p.Reponame, p.DefaultBranch))
+	}
+
+	return jobCounts, nil
+}
+
+// loadProjectsFile reads --projects-file, which lists one
+// vcs_type/username/reponame/branch per line. Empty lines and lines starting
+// with # are ignored.
+func loadProjectsFile(path string) ([]*jobCount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open --projects-file: %s", err)
+	}
+	defer f.Close()
+
+	var jobCounts []*jobCount
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "/", 4)
+		if len(parts) != 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" || parts[3] == "" {
+			return nil, fmt.Errorf("invalid project in --projects-file at line %d: %s", n, line)
+		}
+		jobCounts = append(jobCounts, newSeedJobCount(parts[0], parts[1], parts[2], parts[3]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read --projects-file: %s", err)
+	}
+
+	return jobCounts, nil
+}
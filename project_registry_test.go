@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProjectRegistryReportsZeroUntilExpired(t *testing.T) {
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	registry := newProjectRegistry()
+
+	seen := newQueueStats()
+	seen.addJobs([]*circleCiJob{createCircleCIJobWithLifeCycle("running")})
+	registry.observe(seen, now, time.Hour)
+
+	stats := newQueueStats()
+	registry.expire(now.Add(59 * time.Minute))
+	registry.ensureZeros(stats)
+
+	key := createCircleCIJobWithLifeCycle("running").toKey()
+	jc, ok := stats.notRunningCounts.jobCounts[key]
+	if !ok || jc.Count != 0 {
+		t.Errorf("known project should be reported as 0: %v", jc)
+	}
+	if _, ok := stats.lifeCycleCounts["queued"].jobCounts[key]; !ok {
+		t.Errorf("known project should be reported as 0 in every lifecycle")
+	}
+
+	stats = newQueueStats()
+	registry.expire(now.Add(time.Hour))
+	registry.ensureZeros(stats)
+
+	if len(stats.notRunningCounts.jobCounts) != 0 {
+		t.Errorf("expired project should not be reported: %v", stats.notRunningCounts.jobCounts)
+	}
+}
+
+func TestProjectRegistryDoesNotOverwriteCounts(t *testing.T) {
+	now := time.Now()
+	registry := newProjectRegistry()
+	job := createCircleCIJobWithLifeCycle("running")
+	registry.register(newJobCount(job), time.Time{})
+
+	stats := newQueueStats()
+	stats.addJobs([]*circleCiJob{job})
+	registry.ensureZeros(stats)
+
+	expectedCount := 1
+	actualCount := stats.runningCounts.getTotalCount()
+	if actualCount != expectedCount {
+		t.Errorf("running count is wrong: expected: %d, actual: %d", expectedCount, actualCount)
+	}
+
+	registry.expire(now.Add(365 * 24 * time.Hour))
+	if len(registry.entries) != 1 {
+		t.Errorf("project registered without expiry should never expire")
+	}
+}
+
+func TestProjectRegistryReportsSeedsOnlyWithoutSeries(t *testing.T) {
+	dimensions, err := parseDimensions("resource_class,executor")
+	if err != nil {
+		t.Fatalf("parseDimensions() returned error: %s", err)
+	}
+	original := enabledDimensions
+	enabledDimensions = dimensions
+	defer func() { enabledDimensions = original }()
+
+	registry := newProjectRegistry()
+	registry.register(newSeedJobCount("github", "yuya-takeyama", "jr", "master"), time.Time{})
+
+	stats := newQueueStats()
+	registry.ensureZeros(stats)
+
+	seedKey := newSeedJobCount("github", "yuya-takeyama", "jr", "master").toKey()
+	if _, ok := stats.notRunningCounts.jobCounts[seedKey]; !ok {
+		t.Errorf("seeded project should be reported as 0 before its first build")
+	}
+
+	job := createCircleCIJobWithLifeCycle("running")
+	job.Picard = &circleCiPicard{Executor: "docker"}
+	registry.register(newJobCount(job), time.Time{})
+
+	stats = newQueueStats()
+	registry.ensureZeros(stats)
+
+	if _, ok := stats.notRunningCounts.jobCounts[seedKey]; ok {
+		t.Errorf("seeded project should not be reported next to the series of its builds")
+	}
+	if _, ok := stats.notRunningCounts.jobCounts[job.toKey()]; !ok {
+		t.Errorf("known series should be reported as 0")
+	}
+}
+
+func TestGetFollowedProjects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects" {
+			t.Errorf("unexpected request: %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `[{"vcs_type": "github", "username": "yuya-takeyama", "reponame": "foo", "default_branch": "master"}, {"vcs_type": "github", "username": "yuya-takeyama", "reponame": "empty"}]`)
+	}))
+	defer server.Close()
+
+	originalBaseURL := circleCiAPIBaseURL
+	circleCiAPIBaseURL = server.URL
+	defer func() { circleCiAPIBaseURL = originalBaseURL }()
+
+	projects, err := getFollowedProjects(context.Background())
+	if err != nil {
+		t.Fatalf("getFollowedProjects() returned error: %s", err)
+	}
+
+	if len(projects) != 1 || projects[0].Reponame != "foo" || projects[0].Branch != "master" {
+		t.Errorf("getFollowedProjects() result is wrong: %v", projects)
+	}
+}
+
+func TestUpdateKnownProjectsRetriesFollowedProjects(t *testing.T) {
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `[{"vcs_type": "github", "username": "yuya-takeyama", "reponame": "foo", "default_branch": "master"}]`)
+	}))
+	defer server.Close()
+
+	originalBaseURL := circleCiAPIBaseURL
+	originalProjects := knownProjects
+	originalOpts := opts
+	circleCiAPIBaseURL = server.URL
+	knownProjects = newProjectRegistry()
+	opts.SeedFollowedProjects = true
+	defer func() {
+		circleCiAPIBaseURL = originalBaseURL
+		knownProjects = originalProjects
+		opts = originalOpts
+	}()
+
+	now := time.Now()
+	updateKnownProjects(context.Background(), newQueueStats(), now)
+	if !knownProjects.needsFollowedProjects(now.Add(time.Minute)) {
+		t.Errorf("followed projects should be fetched again after a failure")
+	}
+
+	failing = false
+	stats := newQueueStats()
+	updateKnownProjects(context.Background(), stats, now.Add(time.Minute))
+	if knownProjects.needsFollowedProjects(now.Add(2 * time.Minute)) {
+		t.Errorf("followed projects should not be fetched again until the refresh interval")
+	}
+	if len(stats.notRunningCounts.jobCounts) != 1 {
+		t.Errorf("followed project should be reported as 0: %v", stats.notRunningCounts.jobCounts)
+	}
+}
+
+func TestLoadProjectsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "projects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, "# comment\n\ngithub/yuya-takeyama/foo/master\ngithub/yuya-takeyama/foo/feature/bar\n")
+	f.Close()
+
+	projects, err := loadProjectsFile(f.Name())
+	if err != nil {
+		t.Fatalf("loadProjectsFile() returned error: %s", err)
+	}
+
+	if len(projects) != 2 || projects[1].Branch != "feature/bar" {
+		t.Errorf("loadProjectsFile() result is wrong: %v", projects)
+	}
+}
+
+func TestLoadProjectsFileRejectsInvalidLine(t *testing.T) {
+	f, err := ioutil.TempFile("", "projects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, "github/yuya-takeyama/foo\n")
+	f.Close()
+
+	if _, err := loadProjectsFile(f.Name()); err == nil {
+		t.Errorf("loadProjectsFile() should return error")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prometheusSink keeps the samples of the last check, and exposes them as
+// gauges in the Prometheus text format on every scrape. Scrapes never call
+// the CircleCI API.
+type prometheusSink struct {
+	mu      sync.RWMutex
+	samples []sample
+}
+
+func newPrometheusSink() *prometheusSink {
+	return &prometheusSink{}
+}
+
+func (s *prometheusSink) Name() string {
+	return "Prometheus"
+}
+
+func (s *prometheusSink) Send(ctx context.Context, samples []sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = samples
+
+	return nil
+}
+
+func (s *prometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	s.write(bw)
+	polls.write(bw)
+	bw.Flush()
+}
+
+// write writes the samples grouped by metric name, with dots in names
+// replaced by underscores and tags as labels.
+func (s *prometheusSink) write(w io.Writer) {
+	s.mu.RLock()
+	samples := make([]sample, len(s.samples))
+	copy(samples, s.samples)
+	s.mu.RUnlock()
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Name < samples[j].Name
+	})
+
+	lastName := ""
+	for _, m := range samples {
+		name := prometheusName(m.Name)
+		if name != lastName {
+			fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+			lastName = name
+		}
+		fmt.Fprintf(w, "%s%s %s\n", name, prometheusLabels(m.Tags), formatPrometheusValue(m.Value))
+	}
+}
+
+// servePrometheus serves the samples of sink on /metrics of address.
+func servePrometheus(address string, sink *prometheusSink) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on --prometheus-address: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", sink)
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Fatalf("Prometheus exporter stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+var (
+	prometheusNameRegexp  = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	prometheusLabelRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+	prometheusValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func prometheusName(name string) string {
+	return prometheusNameRegexp.ReplaceAllString(name, "_")
+}
+
+func prometheusLabels(tags []tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	labels := make([]string, len(tags))
+	for i, t := range tags {
+		labels[i] = prometheusLabelRegexp.ReplaceAllString(t.Key, "_") + `="` + prometheusValueReplacer.Replace(t.Value) + `"`
+	}
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatPrometheusValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// pollStatus records the duration and errors of checks. They are exposed
+// only by the Prometheus exporter, as is customary for exporters.
+type pollStatus struct {
+	mu            sync.Mutex
+	duration      time.Duration
+	errors        int
+	lastSuccessAt time.Time
+}
+
+var polls = &pollStatus{}
+
+// record records a check which started at startedAt. It failed if either
+// collecting or sending metrics failed.
+func (p *pollStatus) record(startedAt time.Time, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.duration = time.Since(startedAt)
+	if failed {
+		p.errors++
+	} else {
+		p.lastSuccessAt = startedAt
+	}
+}
+
+func (p *pollStatus) write(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP circleci_queue_poll_duration_seconds Duration of the last check.\n")
+	fmt.Fprintf(w, "# TYPE circleci_queue_poll_duration_seconds gauge\n")
+	fmt.Fprintf(w, "circleci_queue_poll_duration_seconds %s\n", formatPrometheusValue(p.duration.Seconds()))
+	fmt.Fprintf(w, "# HELP circleci_queue_poll_errors_total Number of checks which failed to collect or send metrics.\n")
+	fmt.Fprintf(w, "# TYPE circleci_queue_poll_errors_total counter\n")
+	fmt.Fprintf(w, "circleci_queue_poll_errors_total %d\n", p.errors)
+	if !p.lastSuccessAt.IsZero() {
+		fmt.Fprintf(w, "# HELP circleci_queue_poll_last_success_timestamp_seconds Time when the last successful check started.\n")
+		fmt.Fprintf(w, "# TYPE circleci_queue_poll_last_success_timestamp_seconds gauge\n")
+		fmt.Fprintf(w, "circleci_queue_poll_last_success_timestamp_seconds %d\n", p.lastSuccessAt.Unix())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusSinkServesLastSamples(t *testing.T) {
+	now := time.Now()
+	sink := newPrometheusSink()
+
+	sink.Send(context.Background(), []sample{newSample(now, runningMetricName, 5, nil)})
+	sink.Send(context.Background(), []sample{
+		newSample(now, runningMetricName, 1, []tag{{"branch", "master"}, {"resource_class", `say "hi"`}}),
+		newSample(now, runningMetricName, 2, []tag{{"branch", "feature"}}),
+		newSample(now, truncatedMetricName, 0, nil),
+	})
+
+	server := httptest.NewServer(sink)
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	expected := `# TYPE circleci_queue_running gauge
+circleci_queue_running{branch="master",resource_class="say \"hi\""} 1
+circleci_queue_running{branch="feature"} 2
+# TYPE circleci_queue_truncated gauge
+circleci_queue_truncated 0
+`
+	if !strings.HasPrefix(string(body), expected) {
+		t.Errorf("response is wrong: expected prefix: %q, actual: %q", expected, string(body))
+	}
+	if !strings.Contains(string(body), "circleci_queue_poll_errors_total ") {
+		t.Errorf("response should contain poll metrics: %q", string(body))
+	}
+}
+
+func TestPollStatusRecordsErrors(t *testing.T) {
+	p := &pollStatus{}
+	startedAt := time.Now()
+	p.record(startedAt, false)
+	p.record(startedAt.Add(time.Minute), true)
+
+	var buf strings.Builder
+	p.write(&buf)
+
+	if !strings.Contains(buf.String(), "circleci_queue_poll_errors_total 1\n") {
+		t.Errorf("errors are wrong: %q", buf.String())
+	}
+	if p.lastSuccessAt != startedAt {
+		t.Errorf("last success is wrong: %s", p.lastSuccessAt)
+	}
+}
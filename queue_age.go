@@ -0,0 +1,94 @@
+package main
+
+import (
+	"time"
+)
+
+// waitingLifeCycles are the lifecycles of builds waiting to run.
+var waitingLifeCycles = map[string]bool{
+	"queued":      true,
+	"not_running": true,
+}
+
+// queueAge holds the earliest queued_at of the waiting builds of one
+// vcs_type/username/reponame/branch. OldestQueuedAt is zero when nothing is
+// waiting.
+type queueAge struct {
+	jobCount
+	OldestQueuedAt time.Time
+}
+
+type queueAges struct {
+	queueAges map[string]*queueAge
+}
+
+func newQueueAges() *queueAges {
+	return &queueAges{
+		queueAges: make(map[string]*queueAge),
+	}
+}
+
+func (o *queueAges) observe(job *circleCiJob) {
+	key := job.toKey()
+	qa, ok := o.queueAges[key]
+	if !ok {
+		qa = &queueAge{
+			jobCount: *newJobCount(job),
+		}
+		o.queueAges[key] = qa
+	}
+
+	if !waitingLifeCycles[job.LifeCycle] || job.QueuedAt == nil {
+		return
+	}
+	if qa.OldestQueuedAt.IsZero() || job.QueuedAt.Before(qa.OldestQueuedAt) {
+		qa.OldestQueuedAt = *job.QueuedAt
+	}
+}
+
+// toMetrics returns the age in seconds of the oldest waiting build for each
+// branch, and rolled up for each vcs_type/username.
+func (o *queueAges) toMetrics(now time.Time) []sample {
+	var metrics []sample
+	orgAges := make(map[string]*queueAge)
+
+	for _, qa := range o.queueAges {
+		metrics = append(metrics, newSample(now, oldestAgeMetricName, qa.ageAt(now), qa.toTags()))
+
+		orgKey := qa.VcsType + "/" + qa.Username
+		orgAge, ok := orgAges[orgKey]
+		if !ok {
+			orgAge = &queueAge{jobCount: jobCount{VcsType: qa.VcsType, Username: qa.Username}}
+			orgAges[orgKey] = orgAge
+		}
+		if !qa.OldestQueuedAt.IsZero() && (orgAge.OldestQueuedAt.IsZero() || qa.OldestQueuedAt.Before(orgAge.OldestQueuedAt)) {
+			orgAge.OldestQueuedAt = qa.OldestQueuedAt
+		}
+	}
+
+	for _, orgAge := range orgAges {
+		tags := []tag{
+			{"vcs_type", orgAge.VcsType},
+			{"username", orgAge.Username},
+		}
+		metrics = append(metrics, newSample(now, orgOldestAgeMetricName, orgAge.ageAt(now), tags))
+	}
+
+	return metrics
+}
+
+func (qa *queueAge) ageAt(now time.Time) float64 {
+	if qa.OldestQueuedAt.IsZero() || qa.OldestQueuedAt.After(now) {
+		return 0
+	}
+
+	return now.Sub(qa.OldestQueuedAt).Seconds()
+}
+
+func observeQueueAges(jobs []*circleCiJob, ages *queueAges) {
+	for _, job := range jobs {
+		if isTargetJob(job) {
+			ages.observe(job)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueAgesToMetrics(t *testing.T) {
+	now := time.Date(2018, 10, 1, 1, 0, 0, 0, time.UTC)
+	ages := newQueueAges()
+
+	master := createQueuedJob("master", "not_running", now.Add(-10*time.Minute))
+	olderMaster := createQueuedJob("master", "queued", now.Add(-40*time.Minute))
+	running := createQueuedJob("master", "running", now.Add(-time.Hour))
+	idle := createQueuedJob("idle", "finished", now.Add(-2*time.Hour))
+	for _, job := range []*circleCiJob{master, olderMaster, running, idle} {
+		ages.observe(job)
+	}
+
+	expected := map[string]float64{
+		oldestAgeMetricName + " branch:master": 40 * 60,
+		oldestAgeMetricName + " branch:idle":   0,
+		orgOldestAgeMetricName + " ":           40 * 60,
+	}
+
+	metrics := ages.toMetrics(now)
+	if len(metrics) != len(expected) {
+		t.Fatalf("toMetrics() result is wrong: expected: %d metrics, actual: %d", len(expected), len(metrics))
+	}
+
+	for _, metric := range metrics {
+		branchTag := ""
+		for _, tag := range metric.Tags {
+			if tag.Key == "branch" {
+				branchTag = tag.String()
+			}
+		}
+		key := metric.Name + " " + branchTag
+		expectedAge, ok := expected[key]
+		if !ok {
+			t.Errorf("toMetrics() returned an unexpected metric: %s", key)
+			continue
+		}
+		if actualAge := metric.Value; actualAge != expectedAge {
+			t.Errorf("toMetrics() result of %s is wrong: expected: %f, actual: %f", key, expectedAge, actualAge)
+		}
+	}
+}
+
+func createQueuedJob(branch, lifecycle string, queuedAt time.Time) *circleCiJob {
+	job := createCircleCIJobWithLifeCycle(lifecycle)
+	job.Branch = branch
+	job.QueuedAt = &queuedAt
+
+	return job
+}
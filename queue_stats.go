@@ -0,0 +1,148 @@
+package main
+
+import (
+	"time"
+)
+
+// knownLifeCycles are reported as 0 for every branch even when no build is
+// in them, so that their series do not stop.
+var knownLifeCycles = []string{"queued", "scheduled", "not_run", "not_running", "running", "finished"}
+
+type queueStats struct {
+	runningCounts    *jobCounts
+	notRunningCounts *jobCounts
+
+	// runningContainerCounts and notRunningContainerCounts are weighted by
+	// the parallelism of each build.
+	runningContainerCounts    *jobCounts
+	notRunningContainerCounts *jobCounts
+
+	// lifeCycleCounts and statusCounts are keyed by lifecycle and status of
+	// builds.
+	lifeCycleCounts map[string]*jobCounts
+	statusCounts    map[string]*jobCounts
+
+	// resourceClassCounts holds running/not_running counts for each resource
+	// class regardless of project and branch.
+	resourceClassCounts *resourceClassCounts
+
+	// workflowCounts and pipelineCounts are keyed by workflow status and
+	// pipeline state. They are only filled by the API v2 collector.
+	workflowCounts map[string]*jobCounts
+	pipelineCounts map[string]*jobCounts
+
+	// queueAges holds the earliest queued_at of waiting builds.
+	queueAges *queueAges
+
+	// jobDurations holds wait and run times of builds finished since the
+	// previous check.
+	jobDurations *jobDurations
+
+	// truncated is true when paging stopped at --max-pages before reaching
+	// the horizon, so older builds may be missing from the counts.
+	truncated bool
+}
+
+func newQueueStats() *queueStats {
+	return &queueStats{
+		runningCounts:             newJobCounts(),
+		notRunningCounts:          newJobCounts(),
+		runningContainerCounts:    newJobCounts(),
+		notRunningContainerCounts: newJobCounts(),
+		lifeCycleCounts:           make(map[string]*jobCounts),
+		statusCounts:              make(map[string]*jobCounts),
+		resourceClassCounts:       newResourceClassCounts(),
+		workflowCounts:            make(map[string]*jobCounts),
+		pipelineCounts:            make(map[string]*jobCounts),
+		queueAges:                 newQueueAges(),
+		jobDurations:              newJobDurations(),
+	}
+}
+
+// addJobs counts jobs by running/not_running (also weighted by parallelism),
+// lifecycle and status.
+func (s *queueStats) addJobs(jobs []*circleCiJob) {
+	incrCounts(jobs, s.runningCounts, s.notRunningCounts, func(*circleCiJob) int { return 1 })
+	incrCounts(jobs, s.runningContainerCounts, s.notRunningContainerCounts, (*circleCiJob).containers)
+
+	for _, job := range jobs {
+		if !isTargetJob(job) {
+			continue
+		}
+
+		for _, lifeCycle := range knownLifeCycles {
+			getStateCounts(s.lifeCycleCounts, lifeCycle).ensure(job)
+		}
+		if job.LifeCycle != "" {
+			getStateCounts(s.lifeCycleCounts, job.LifeCycle).incr(job)
+		}
+		if job.Status != "" {
+			getStateCounts(s.statusCounts, job.Status).incr(job)
+		}
+		s.resourceClassCounts.add(job)
+	}
+}
+
+// ensureZero reports the project/branch of jc as 0 unless it is counted.
+func (s *queueStats) ensureZero(jc *jobCount) {
+	s.runningCounts.ensureZero(jc)
+	s.notRunningCounts.ensureZero(jc)
+	s.runningContainerCounts.ensureZero(jc)
+	s.notRunningContainerCounts.ensureZero(jc)
+	for _, lifeCycle := range knownLifeCycles {
+		getStateCounts(s.lifeCycleCounts, lifeCycle).ensureZero(jc)
+	}
+}
+
+func (s *queueStats) incrWorkflow(status string, job *circleCiJob) {
+	incrStateCounts(s.workflowCounts, status, job)
+}
+
+func (s *queueStats) incrPipeline(state string, job *circleCiJob) {
+	incrStateCounts(s.pipelineCounts, state, job)
+}
+
+func incrStateCounts(stateCounts map[string]*jobCounts, state string, job *circleCiJob) {
+	if !isTargetJob(job) {
+		return
+	}
+
+	getStateCounts(stateCounts, state).incr(job)
+}
+
+func getStateCounts(stateCounts map[string]*jobCounts, state string) *jobCounts {
+	counts, ok := stateCounts[state]
+	if !ok {
+		counts = newJobCounts()
+		stateCounts[state] = counts
+	}
+
+	return counts
+}
+
+func (s *queueStats) toMetrics(now time.Time) []sample {
+	metrics := s.runningCounts.toMetrics(now, runningMetricName)
+	metrics = append(metrics, s.notRunningCounts.toMetrics(now, notRunningMetricName)...)
+	metrics = append(metrics, s.runningContainerCounts.toMetrics(now, runningContainersMetricName)...)
+	metrics = append(metrics, s.notRunningContainerCounts.toMetrics(now, notRunningContainersMetricName)...)
+
+	for lifeCycle, counts := range s.lifeCycleCounts {
+		metrics = append(metrics, counts.toMetricsWithTags(now, lifeCycleMetricName, []tag{{"lifecycle", lifeCycle}})...)
+	}
+	for status, counts := range s.statusCounts {
+		metrics = append(metrics, counts.toMetricsWithTags(now, statusMetricName, []tag{{"status", status}})...)
+	}
+	metrics = append(metrics, s.resourceClassCounts.toMetrics(now)...)
+	for status, counts := range s.workflowCounts {
+		metrics = append(metrics, counts.toMetricsWithTags(now, workflowMetricName, []tag{{"status", status}})...)
+	}
+	for state, counts := range s.pipelineCounts {
+		metrics = append(metrics, counts.toMetricsWithTags(now, pipelineMetricName, []tag{{"state", state}})...)
+	}
+
+	metrics = append(metrics, s.queueAges.toMetrics(now)...)
+	metrics = append(metrics, s.jobDurations.toMetrics(now)...)
+	metrics = append(metrics, newSample(now, truncatedMetricName, boolToFloat(s.truncated), nil))
+
+	return metrics
+}
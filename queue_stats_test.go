@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestQueueStatsAddJobs(t *testing.T) {
+	stats := newQueueStats()
+
+	running := createCircleCIJobWithLifeCycle("running")
+	running.Status = "running"
+	queued := createCircleCIJobWithLifeCycle("queued")
+	queued.Status = "queued"
+	finished := createCircleCIJobWithLifeCycle("finished")
+	finished.Status = "success"
+	stats.addJobs([]*circleCiJob{running, queued, finished, finished})
+
+	key := running.toKey()
+	expectedLifeCycleCounts := map[string]int{
+		"queued":      1,
+		"scheduled":   0,
+		"not_run":     0,
+		"not_running": 0,
+		"running":     1,
+		"finished":    2,
+	}
+	if len(stats.lifeCycleCounts) != len(expectedLifeCycleCounts) {
+		t.Errorf("addJobs() result is wrong: expected: %d lifecycles, actual: %d", len(expectedLifeCycleCounts), len(stats.lifeCycleCounts))
+	}
+	for lifeCycle, expected := range expectedLifeCycleCounts {
+		counts, ok := stats.lifeCycleCounts[lifeCycle]
+		if !ok {
+			t.Errorf("addJobs() result is wrong: lifecycle %s is missing", lifeCycle)
+			continue
+		}
+		if actual := counts.jobCounts[key].Count; actual != expected {
+			t.Errorf("addJobs() result is wrong: count of lifecycle %s: expected: %d, actual: %d", lifeCycle, expected, actual)
+		}
+	}
+
+	expectedStatusCounts := map[string]int{"running": 1, "queued": 1, "success": 2}
+	if len(stats.statusCounts) != len(expectedStatusCounts) {
+		t.Errorf("addJobs() result is wrong: expected: %d statuses, actual: %d", len(expectedStatusCounts), len(stats.statusCounts))
+	}
+	for status, expected := range expectedStatusCounts {
+		if actual := stats.statusCounts[status].getTotalCount(); actual != expected {
+			t.Errorf("addJobs() result is wrong: count of status %s: expected: %d, actual: %d", status, expected, actual)
+		}
+	}
+
+	if actual := stats.runningCounts.getTotalCount(); actual != 1 {
+		t.Errorf("addJobs() result is wrong: running count: expected: %d, actual: %d", 1, actual)
+	}
+}
+
+func TestQueueStatsAddJobsWeightsContainers(t *testing.T) {
+	stats := newQueueStats()
+
+	running := createCircleCIJobWithLifeCycle("running")
+	running.Parallel = 20
+	notRunning := createCircleCIJobWithLifeCycle("not_running")
+	notRunning.Parallel = 4
+	sequential := createCircleCIJobWithLifeCycle("not_running")
+	stats.addJobs([]*circleCiJob{running, notRunning, sequential})
+
+	expectedRunning := 20
+	if actual := stats.runningContainerCounts.getTotalCount(); actual != expectedRunning {
+		t.Errorf("addJobs() result is wrong: running containers: expected: %d, actual: %d", expectedRunning, actual)
+	}
+
+	expectedNotRunning := 5
+	if actual := stats.notRunningContainerCounts.getTotalCount(); actual != expectedNotRunning {
+		t.Errorf("addJobs() result is wrong: not_running containers: expected: %d, actual: %d", expectedNotRunning, actual)
+	}
+
+	if actual := stats.notRunningCounts.getTotalCount(); actual != 2 {
+		t.Errorf("addJobs() result is wrong: not_running count: expected: %d, actual: %d", 2, actual)
+	}
+}
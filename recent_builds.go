@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// recentBuildsPageSize is the maximum limit accepted by the recent-builds API.
+const recentBuildsPageSize = 100
+
+var circleCiAPIBaseURL = "https://circleci.com/api/v1.1"
+
+type circleCiJob struct {
+	VcsType         string     `json:"vcs_type"`
+	Username        string     `json:"username"`
+	Reponame        string     `json:"reponame"`
+	Branch          string     `json:"branch"`
+	BuildNum        int        `json:"build_num"`
+	LifeCycle       string     `json:"lifecycle"`
+	Status          string     `json:"status"`
+	QueuedAt        *time.Time `json:"queued_at"`
+	StartTime       *time.Time `json:"start_time"`
+	StopTime        *time.Time `json:"stop_time"`
+	BuildTimeMillis *int64     `json:"build_time_millis"`
+
+	Workflows *circleCiWorkflows `json:"workflows"`
+	Picard    *circleCiPicard    `json:"picard"`
+	Platform  string             `json:"platform"`
+	Parallel  int                `json:"parallel"`
+}
+
+type circleCiWorkflows struct {
+	WorkflowName string `json:"workflow_name"`
+	JobName      string `json:"job_name"`
+}
+
+type circleCiPicard struct {
+	ResourceClass *struct {
+		Class string `json:"class"`
+	} `json:"resource_class"`
+	Executor string `json:"executor"`
+}
+
+func (job *circleCiJob) resourceClass() string {
+	if job.Picard == nil || job.Picard.ResourceClass == nil {
+		return ""
+	}
+
+	return job.Picard.ResourceClass.Class
+}
+
+// executor returns the executor like "docker" or "machine". Builds on
+// CircleCI 1.0 have no picard, so the platform is used instead.
+func (job *circleCiJob) executor() string {
+	if job.Picard != nil && job.Picard.Executor != "" {
+		return job.Picard.Executor
+	}
+	if job.Platform == "1.0" {
+		return "platform-1.0"
+	}
+
+	return ""
+}
+
+// containers returns the number of containers the build occupies.
+func (job *circleCiJob) containers() int {
+	if job.Parallel < 1 {
+		return 1
+	}
+
+	return job.Parallel
+}
+
+func (job *circleCiJob) toKey() string {
+	return newJobCount(job).toKey()
+}
+
+// toBuildKey identifies a single build, so that a build which shifts onto the
+// next page while paging is not counted twice.
+func (job *circleCiJob) toBuildKey() string {
+	return fmt.Sprintf("%s/%s/%s/%d", job.VcsType, job.Username, job.Reponame, job.BuildNum)
+}
+
+func getJobCounts(ctx context.Context) (*queueStats, error) {
+	stats := newQueueStats()
+	seen := make(map[string]bool)
+
+	now := time.Now()
+	horizon := getHorizon(now)
+
+	pruneBefore := horizon
+	if pruneBefore.IsZero() {
+		pruneBefore = now.Add(-finishedBuildRetention)
+	}
+	defer finishedBuilds.prune(pruneBefore)
+
+	// Finished builds are counted only once every page has been fetched, so
+	// that builds of a check which fails halfway are counted by the next one.
+	var fetchedJobs []*circleCiJob
+
+	for page := 0; page < opts.MaxPages; page++ {
+		jobs, err := getRecentBuilds(ctx, page*recentBuildsPageSize)
+		if err != nil {
+			return stats, err
+		}
+
+		var newJobs []*circleCiJob
+		for _, job := range jobs {
+			key := job.toBuildKey()
+			if !seen[key] {
+				seen[key] = true
+				newJobs = append(newJobs, job)
+			}
+		}
+		stats.addJobs(newJobs)
+		observeQueueAges(newJobs, stats.queueAges)
+		fetchedJobs = append(fetchedJobs, newJobs...)
+
+		if len(jobs) < recentBuildsPageSize || reachesHorizon(jobs, horizon) {
+			addFinishedJobDurations(fetchedJobs, stats.jobDurations, finishedBuilds)
+			return stats, nil
+		}
+	}
+
+	addFinishedJobDurations(fetchedJobs, stats.jobDurations, finishedBuilds)
+	stats.truncated = true
+
+	return stats, nil
+}
+
+func getRecentBuilds(ctx context.Context, offset int) ([]*circleCiJob, error) {
+	url := circleCiAPIBaseURL + "/recent-builds?limit=" + strconv.Itoa(recentBuildsPageSize) + "&offset=" + strconv.Itoa(offset)
+
+	var jobs []*circleCiJob
+	if err := getCircleCiJSON(ctx, "recent builds", url, &jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// getHorizon returns the time before which paging stops, or the zero time
+// when --horizon is disabled.
+func getHorizon(now time.Time) time.Time {
+	if opts.Horizon <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(-time.Duration(opts.Horizon) * time.Second)
+}
+
+// reachesHorizon reports whether the page contains a build queued before the
+// horizon. Pages are ordered from newest to oldest, so later pages would only
+// contain older builds.
+func reachesHorizon(jobs []*circleCiJob, horizon time.Time) bool {
+	if horizon.IsZero() {
+		return false
+	}
+
+	for _, job := range jobs {
+		if job.QueuedAt != nil && job.QueuedAt.Before(horizon) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// incrCounts counts jobs by running/not_running, each weighted by weight, and
+// ensures both counts of every target job.
+func incrCounts(jobs []*circleCiJob, runningCounts, notRunningCounts *jobCounts, weight func(job *circleCiJob) int) {
+	for _, job := range jobs {
+		if isTargetJob(job) {
+			if job.LifeCycle == "running" {
+				runningCounts.incrBy(job, weight(job))
+				notRunningCounts.ensure(job)
+			} else if job.LifeCycle == "not_running" {
+				runningCounts.ensure(job)
+				notRunningCounts.incrBy(job, weight(job))
+			} else {
+				runningCounts.ensure(job)
+				notRunningCounts.ensure(job)
+			}
+		}
+	}
+}
+
+func isTargetJob(job *circleCiJob) bool {
+	return isTargetUsername(job.Username)
+}
+
+func isTargetUsername(username string) bool {
+	if len(targetUsernames) == 0 {
+		return true
+	}
+	for _, targetUsername := range targetUsernames {
+		if username == targetUsername {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetJobCountsPaginates(t *testing.T) {
+	now := time.Now()
+	builds := createRecentBuilds(250, "not_running", now)
+	defer setRecentBuildsServer(t, builds)()
+	defer setPagingOptions(10, 0)()
+
+	stats, err := getJobCounts(context.Background())
+	if err != nil {
+		t.Fatalf("getJobCounts() returned error: %s", err)
+	}
+
+	expectedCount := 250
+	actualCount := stats.notRunningCounts.getTotalCount()
+	if actualCount != expectedCount {
+		t.Errorf("getJobCounts() result is wrong: expected: %d, actual: %d", expectedCount, actualCount)
+	}
+	if stats.truncated {
+		t.Errorf("getJobCounts() result is wrong: expected not to be truncated")
+	}
+}
+
+func TestGetJobCountsStopsAtHorizon(t *testing.T) {
+	now := time.Now()
+	builds := createRecentBuilds(300, "not_running", now)
+	defer setRecentBuildsServer(t, builds)()
+	// Builds are queued one minute apart, so the horizon falls on the second page.
+	defer setPagingOptions(10, 150*60)()
+
+	stats, err := getJobCounts(context.Background())
+	if err != nil {
+		t.Fatalf("getJobCounts() returned error: %s", err)
+	}
+
+	expectedCount := 200
+	actualCount := stats.notRunningCounts.getTotalCount()
+	if actualCount != expectedCount {
+		t.Errorf("getJobCounts() result is wrong: expected: %d, actual: %d", expectedCount, actualCount)
+	}
+	if stats.truncated {
+		t.Errorf("getJobCounts() result is wrong: expected not to be truncated")
+	}
+}
+
+func TestGetJobCountsTruncatesAtMaxPages(t *testing.T) {
+	now := time.Now()
+	builds := createRecentBuilds(300, "not_running", now)
+	defer setRecentBuildsServer(t, builds)()
+	defer setPagingOptions(2, 0)()
+
+	stats, err := getJobCounts(context.Background())
+	if err != nil {
+		t.Fatalf("getJobCounts() returned error: %s", err)
+	}
+
+	expectedCount := 200
+	actualCount := stats.notRunningCounts.getTotalCount()
+	if actualCount != expectedCount {
+		t.Errorf("getJobCounts() result is wrong: expected: %d, actual: %d", expectedCount, actualCount)
+	}
+	if !stats.truncated {
+		t.Errorf("getJobCounts() result is wrong: expected to be truncated")
+	}
+}
+
+func TestGetJobCountsSkipsDuplicatedBuilds(t *testing.T) {
+	now := time.Now()
+	builds := createRecentBuilds(150, "not_running", now)
+	// A new build shifts the last build of the first page onto the second page.
+	builds = append(builds[:100], builds[99:]...)
+	defer setRecentBuildsServer(t, builds)()
+	defer setPagingOptions(10, 0)()
+
+	stats, err := getJobCounts(context.Background())
+	if err != nil {
+		t.Fatalf("getJobCounts() returned error: %s", err)
+	}
+
+	expectedCount := 150
+	actualCount := stats.notRunningCounts.getTotalCount()
+	if actualCount != expectedCount {
+		t.Errorf("getJobCounts() result is wrong: expected: %d, actual: %d", expectedCount, actualCount)
+	}
+}
+
+func TestGetJobCountsCountsFinishedBuildsAfterFailedPage(t *testing.T) {
+	original := finishedBuilds
+	finishedBuilds = &finishedBuildTracker{counted: make(map[string]time.Time)}
+	defer func() { finishedBuilds = original }()
+
+	now := time.Now()
+	builds := make([]*circleCiJob, 150)
+	for i := range builds {
+		builds[i] = createFinishedJob(150-i, now.Add(-time.Duration(i)*100*time.Millisecond), time.Second, time.Second)
+	}
+
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if offset > 0 && failing {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		end := offset + recentBuildsPageSize
+		if end > len(builds) {
+			end = len(builds)
+		}
+		json.NewEncoder(w).Encode(builds[offset:end])
+	}))
+	defer server.Close()
+
+	originalBaseURL := circleCiAPIBaseURL
+	circleCiAPIBaseURL = server.URL
+	defer func() { circleCiAPIBaseURL = originalBaseURL }()
+	defer setPagingOptions(10, 0)()
+	opts.Interval = 60
+
+	if _, err := getJobCounts(context.Background()); err == nil {
+		t.Fatalf("getJobCounts() should return error when the second page fails")
+	}
+
+	failing = false
+	stats, err := getJobCounts(context.Background())
+	if err != nil {
+		t.Fatalf("getJobCounts() returned error: %s", err)
+	}
+
+	actualCount := 0
+	for _, jd := range stats.jobDurations.jobDurations {
+		actualCount += jd.Count
+	}
+	if actualCount != len(builds) {
+		t.Errorf("finished builds of the failed check should be counted by the next one: expected: %d, actual: %d", len(builds), actualCount)
+	}
+}
+
+func createRecentBuilds(n int, lifecycle string, now time.Time) []*circleCiJob {
+	builds := make([]*circleCiJob, n)
+	for i := 0; i < n; i++ {
+		queuedAt := now.Add(-time.Duration(i) * time.Minute)
+		job := createCircleCIJobWithLifeCycle(lifecycle)
+		job.BuildNum = n - i
+		job.QueuedAt = &queuedAt
+		builds[i] = job
+	}
+
+	return builds
+}
+
+func setRecentBuildsServer(t *testing.T, builds []*circleCiJob) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("circle-token") != "" {
+			t.Errorf("CircleCI token must not be sent in the query string")
+		}
+
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+		page := []*circleCiJob{}
+		if offset < len(builds) {
+			end := offset + limit
+			if end > len(builds) {
+				end = len(builds)
+			}
+			page = builds[offset:end]
+		}
+
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			t.Errorf("failed to encode recent builds: %s", err)
+		}
+	}))
+
+	originalBaseURL := circleCiAPIBaseURL
+	circleCiAPIBaseURL = server.URL
+
+	return func() {
+		circleCiAPIBaseURL = originalBaseURL
+		server.Close()
+	}
+}
+
+func setPagingOptions(maxPages, horizon int) func() {
+	original := opts
+	opts.MaxPages = maxPages
+	opts.Horizon = horizon
+
+	return func() {
+		opts = original
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// secrets returns the credentials which must never appear in logs.
+func secrets() []string {
+	return []string{
+		os.Getenv("CIRCLECI_API_TOKEN"),
+		os.Getenv("DATADOG_API_KEY"),
+		os.Getenv("INFLUXDB_TOKEN"),
+		os.Getenv("INFLUXDB_PASSWORD"),
+	}
+}
+
+// redactString replaces every secret in s with "redacted".
+func redactString(s string) string {
+	for _, secret := range secrets() {
+		if len(secret) > 0 {
+			s = strings.Replace(s, secret, "redacted", -1)
+		}
+	}
+
+	return s
+}
+
+// redactError returns err with every secret in its message replaced with
+// "redacted". err itself is returned if it contains none, so that its type is
+// kept.
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	errString := redactString(err.Error())
+	if errString == err.Error() {
+		return err
+	}
+
+	return fmt.Errorf("%s", errString)
+}
+
+// redactingWriter removes secrets from everything written to the underlying
+// writer. It is used for the log output and the debug dump as a last line of
+// defense.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, redactString(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedactError(t *testing.T) {
+	defer setSecretEnv("circle-secret", "datadog-secret")()
+
+	err := redactError(errors.New("Get https://circleci.com/?circle-token=circle-secret&api_key=datadog-secret: EOF"))
+	expected := "Get https://circleci.com/?circle-token=redacted&api_key=redacted: EOF"
+	if err.Error() != expected {
+		t.Errorf("redactError() result is wrong: expected: %s, actual: %s", expected, err.Error())
+	}
+
+	original := errors.New("no secrets")
+	if redactError(original) != original {
+		t.Errorf("redactError() should return the original error when nothing is redacted")
+	}
+
+	if redactError(nil) != nil {
+		t.Errorf("redactError(nil) should return nil")
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	defer setSecretEnv("circle-secret", "")()
+
+	buf := &bytes.Buffer{}
+	w := &redactingWriter{w: buf}
+	input := "token is circle-secret\n"
+	n, err := w.Write([]byte(input))
+	if err != nil {
+		t.Fatalf("Write() returned error: %s", err)
+	}
+	if n != len(input) {
+		t.Errorf("Write() result is wrong: expected: %d, actual: %d", len(input), n)
+	}
+	if strings.Contains(buf.String(), "circle-secret") {
+		t.Errorf("Write() leaked the secret: %s", buf.String())
+	}
+}
+
+func setSecretEnv(circleCiToken, datadogAPIKey string) func() {
+	originalToken := os.Getenv("CIRCLECI_API_TOKEN")
+	originalKey := os.Getenv("DATADOG_API_KEY")
+	os.Setenv("CIRCLECI_API_TOKEN", circleCiToken)
+	os.Setenv("DATADOG_API_KEY", datadogAPIKey)
+
+	return func() {
+		os.Setenv("CIRCLECI_API_TOKEN", originalToken)
+		os.Setenv("DATADOG_API_KEY", originalKey)
+	}
+}
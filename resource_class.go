@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// resourceClassCount holds running/not_running counts of one resource class
+// and executor, across all projects and branches of a vcs_type/username.
+type resourceClassCount struct {
+	VcsType         string
+	Username        string
+	ResourceClass   string
+	Executor        string
+	RunningCount    int
+	NotRunningCount int
+}
+
+func (rc *resourceClassCount) toTags() []tag {
+	return []tag{
+		{"vcs_type", rc.VcsType},
+		{"username", rc.Username},
+		{"resource_class", rc.ResourceClass},
+		{"executor", rc.Executor},
+	}
+}
+
+type resourceClassCounts struct {
+	resourceClassCounts map[string]*resourceClassCount
+}
+
+func newResourceClassCounts() *resourceClassCounts {
+	return &resourceClassCounts{
+		resourceClassCounts: make(map[string]*resourceClassCount),
+	}
+}
+
+// add counts a running or not_running job. Jobs in other lifecycles only
+// ensure their resource class is reported as 0.
+func (o *resourceClassCounts) add(job *circleCiJob) {
+	resourceClass, executor := job.resourceClass(), job.executor()
+	if resourceClass == "" && executor == "" {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s", job.VcsType, job.Username, resourceClass, executor)
+	rc, ok := o.resourceClassCounts[key]
+	if !ok {
+		rc = &resourceClassCount{
+			VcsType:       job.VcsType,
+			Username:      job.Username,
+			ResourceClass: resourceClass,
+			Executor:      executor,
+		}
+		o.resourceClassCounts[key] = rc
+	}
+
+	switch job.LifeCycle {
+	case "running":
+		rc.RunningCount++
+	case "not_running":
+		rc.NotRunningCount++
+	}
+}
+
+func (o *resourceClassCounts) toMetrics(now time.Time) []sample {
+	var metrics []sample
+
+	for _, rc := range o.resourceClassCounts {
+		tags := rc.toTags()
+		metrics = append(metrics,
+			newSample(now, resourceClassRunningMetricName, float64(rc.RunningCount), tags),
+			newSample(now, resourceClassNotRunningMetricName, float64(rc.NotRunningCount), tags),
+		)
+	}
+
+	return metrics
+}
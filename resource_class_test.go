@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResourceClassCountsAdd(t *testing.T) {
+	var jobs []*circleCiJob
+	err := json.Unmarshal([]byte(`[
+		{"username": "yuya-takeyama", "reponame": "jr", "lifecycle": "running", "picard": {"resource_class": {"class": "large"}, "executor": "docker"}},
+		{"username": "yuya-takeyama", "reponame": "jp", "lifecycle": "not_running", "picard": {"resource_class": {"class": "large"}, "executor": "docker"}},
+		{"username": "yuya-takeyama", "reponame": "jr", "lifecycle": "not_running", "picard": {"resource_class": {"class": "medium"}, "executor": "macos"}},
+		{"username": "yuya-takeyama", "reponame": "jr", "lifecycle": "finished", "picard": {"resource_class": {"class": "small"}, "executor": "docker"}},
+		{"username": "yuya-takeyama", "reponame": "jr", "lifecycle": "running", "platform": "1.0"}
+	]`), &jobs)
+	if err != nil {
+		t.Fatalf("failed to parse jobs: %s", err)
+	}
+
+	counts := newResourceClassCounts()
+	for _, job := range jobs {
+		counts.add(job)
+	}
+
+	expected := map[string][2]int{
+		"/yuya-takeyama/large/docker":  {1, 1},
+		"/yuya-takeyama/medium/macos":  {0, 1},
+		"/yuya-takeyama/small/docker":  {0, 0},
+		"/yuya-takeyama//platform-1.0": {1, 0},
+	}
+	if len(counts.resourceClassCounts) != len(expected) {
+		t.Errorf("add() result is wrong: expected: %d resource classes, actual: %d", len(expected), len(counts.resourceClassCounts))
+	}
+	for key, expectedCounts := range expected {
+		rc, ok := counts.resourceClassCounts[key]
+		if !ok {
+			t.Errorf("add() result is wrong: %s is missing", key)
+			continue
+		}
+		if rc.RunningCount != expectedCounts[0] || rc.NotRunningCount != expectedCounts[1] {
+			t.Errorf("add() result of %s is wrong: expected: %v, actual: [%d %d]", key, expectedCounts, rc.RunningCount, rc.NotRunningCount)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+var retryInitialInterval = 1 * time.Second
+var retryMaxInterval = 30 * time.Second
+
+// retryAfterError is returned for responses which tell how long to wait
+// before retrying with the Retry-After header.
+type retryAfterError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+// circleCiBackOff is an exponential backoff with jitter which waits at least
+// as long as Retry-After, and stops instead of sleeping past the deadline.
+type circleCiBackOff struct {
+	backoff.BackOff
+	deadline   time.Time
+	retryAfter time.Duration
+}
+
+func newCircleCiBackOff(ctx context.Context) *circleCiBackOff {
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = retryInitialInterval
+	exp.MaxInterval = retryMaxInterval
+	exp.MaxElapsedTime = 0
+
+	deadline, _ := ctx.Deadline()
+
+	return &circleCiBackOff{
+		BackOff:  backoff.WithMaxRetries(exp, uint64(opts.MaxRetries)),
+		deadline: deadline,
+	}
+}
+
+func (b *circleCiBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop {
+		return backoff.Stop
+	}
+
+	if b.retryAfter > next {
+		next = b.retryAfter
+	}
+	b.retryAfter = 0
+
+	if !b.deadline.IsZero() && time.Now().Add(next).After(b.deadline) {
+		return backoff.Stop
+	}
+
+	return next
+}
+
+// retryCircleCi runs operation until it succeeds, returns a permanent error,
+// runs out of --max-retries or would run past the deadline of ctx.
+func retryCircleCi(ctx context.Context, operation func() error) error {
+	b := newCircleCiBackOff(ctx)
+
+	return backoff.RetryNotify(func() error {
+		err := operation()
+		if retryAfterErr, ok := err.(*retryAfterError); ok {
+			b.retryAfter = retryAfterErr.retryAfter
+		}
+		return err
+	}, backoff.WithContext(b, ctx), func(err error, wait time.Duration) {
+		log.Printf("retrying request to CircleCI API in %s: %s", wait, err)
+	})
+}
+
+// isRetryableStatus reports whether a response status is worth retrying.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= 500
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of
+// seconds or an HTTP date. It returns 0 when the header is absent or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
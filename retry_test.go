@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetCircleCiJSONRetriesTransientErrors(t *testing.T) {
+	defer setRetryOptions(3)()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 3:
+			fmt.Fprint(w, `[{"username": "yuya`)
+		default:
+			fmt.Fprint(w, `[{"username": "yuya-takeyama"}]`)
+		}
+	}))
+	defer server.Close()
+
+	var jobs []*circleCiJob
+	if err := getCircleCiJSON(context.Background(), "recent builds", server.URL, &jobs); err != nil {
+		t.Fatalf("getCircleCiJSON() returned error: %s", err)
+	}
+
+	expectedRequests := 4
+	if requests != expectedRequests {
+		t.Errorf("number of requests is wrong: expected: %d, actual: %d", expectedRequests, requests)
+	}
+	if len(jobs) != 1 || jobs[0].Username != "yuya-takeyama" {
+		t.Errorf("getCircleCiJSON() result is wrong: %v", jobs)
+	}
+}
+
+func TestGetCircleCiJSONDoesNotRetryClientErrors(t *testing.T) {
+	defer setRetryOptions(3)()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var jobs []*circleCiJob
+	if err := getCircleCiJSON(context.Background(), "recent builds", server.URL, &jobs); err == nil {
+		t.Errorf("getCircleCiJSON() should return error")
+	}
+
+	expectedRequests := 1
+	if requests != expectedRequests {
+		t.Errorf("number of requests is wrong: expected: %d, actual: %d", expectedRequests, requests)
+	}
+}
+
+func TestGetCircleCiJSONStopsBeforeDeadline(t *testing.T) {
+	defer setRetryOptions(3)()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var jobs []*circleCiJob
+	if err := getCircleCiJSON(ctx, "recent builds", server.URL, &jobs); err == nil {
+		t.Errorf("getCircleCiJSON() should return error")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("getCircleCiJSON() should give up without waiting for Retry-After: %s", elapsed)
+	}
+
+	expectedRequests := 1
+	if requests != expectedRequests {
+		t.Errorf("number of requests is wrong: expected: %d, actual: %d", expectedRequests, requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := map[string]time.Duration{
+		"":                              0,
+		"30":                            30 * time.Second,
+		"-1":                            0,
+		"Mon, 01 Oct 2018 00:01:00 GMT": time.Minute,
+		"Sun, 30 Sep 2018 00:00:00 GMT": 0,
+		"invalid":                       0,
+	}
+
+	for value, expected := range cases {
+		actual := parseRetryAfter(value, now)
+		if actual != expected {
+			t.Errorf("parseRetryAfter(%q) result is wrong: expected: %s, actual: %s", value, expected, actual)
+		}
+	}
+}
+
+func setRetryOptions(maxRetries int) func() {
+	original := opts
+	originalInitialInterval := retryInitialInterval
+	opts.MaxRetries = maxRetries
+	retryInitialInterval = time.Millisecond
+
+	return func() {
+		opts = original
+		retryInitialInterval = originalInitialInterval
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+)
+
+// tag is a key-value pair attached to a sample, such as branch:master.
+type tag struct {
+	Key   string
+	Value string
+}
+
+// String returns the tag in the key:value form of Datadog.
+func (t tag) String() string {
+	return t.Key + ":" + t.Value
+}
+
+// sample is a gauge value of one series at one point in time. It does not
+// depend on the backend it is sent to; each Sink converts it to its own
+// format.
+type sample struct {
+	Name      string
+	Value     float64
+	Tags      []tag
+	Timestamp time.Time
+}
+
+func newSample(now time.Time, name string, value float64, tags []tag) sample {
+	return sample{
+		Name:      name,
+		Value:     value,
+		Tags:      tags,
+		Timestamp: now,
+	}
+}
+
+func tagStrings(tags []tag) []string {
+	strs := make([]string, len(tags))
+	for i, t := range tags {
+		strs[i] = t.String()
+	}
+
+	return strs
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// scheduler runs poll on start and on every interval boundary of the wall
+// clock, and never runs two polls at once. A tick which comes while a poll is
+// still running is skipped, or with delayWhenBusy, run as soon as the poll
+// finishes. Only one tick is kept waiting; any more are skipped.
+type scheduler struct {
+	interval      time.Duration
+	delayWhenBusy bool
+	poll          pollFunc
+
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	ctx     context.Context
+	busy    bool
+	pending *time.Time
+	skipped int
+
+	// stopped is set once run returns. Errors of polls finishing after that
+	// are kept in stopErr for wait.
+	stopped bool
+	stopErr error
+}
+
+// pollFunc is run on every tick. It returns an error only when collected
+// metrics could not be sent.
+type pollFunc func(ctx context.Context, scheduledAt time.Time, skipped int) error
+
+var errShutdownTimeout = errors.New("timed out waiting for the running check to finish")
+
+func newScheduler(interval time.Duration, delayWhenBusy bool, poll pollFunc) *scheduler {
+	return &scheduler{
+		interval:      interval,
+		delayWhenBusy: delayWhenBusy,
+		poll:          poll,
+	}
+}
+
+// run ticks once right away, so that a restart leaves no gap in metrics, and
+// then on every interval boundary until ctx is canceled. ctx is also passed to
+// every poll, so canceling it cancels the running poll as well.
+func (s *scheduler) run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	s.tick(time.Now())
+
+	for {
+		next := nextTick(time.Now(), s.interval)
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.stop()
+			return
+		case <-timer.C:
+			s.tick(next)
+		}
+	}
+}
+
+func (s *scheduler) tick(scheduledAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	if !s.busy {
+		s.start(scheduledAt)
+		return
+	}
+
+	if s.delayWhenBusy && s.pending == nil {
+		s.pending = &scheduledAt
+		log.Printf("delaying the check scheduled at %s until the previous check finishes", scheduledAt.Format(time.RFC3339))
+		return
+	}
+
+	s.skipped++
+	log.Printf("skipped the check scheduled at %s because the previous check is still running", scheduledAt.Format(time.RFC3339))
+}
+
+// start must be called with s.mu held.
+func (s *scheduler) start(scheduledAt time.Time) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s.busy = true
+	skipped := s.skipped
+	s.skipped = 0
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.finish(s.poll(ctx, scheduledAt, skipped))
+	}()
+}
+
+func (s *scheduler) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.busy = false
+	if s.stopped {
+		if err != nil {
+			s.stopErr = err
+		}
+		return
+	}
+
+	if s.pending != nil {
+		scheduledAt := *s.pending
+		s.pending = nil
+		s.start(scheduledAt)
+	}
+}
+
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	s.pending = nil
+}
+
+// wait waits up to timeout for the running poll to finish after run returns,
+// and returns its error.
+func (s *scheduler) wait(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.stopErr
+	case <-time.After(timeout):
+		return errShutdownTimeout
+	}
+}
+
+// nextTick returns the first multiple of interval on the wall clock after now.
+func nextTick(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
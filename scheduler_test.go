@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextTick(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 34, 56, 0, time.UTC)
+
+	expected := time.Date(2018, 10, 1, 12, 35, 0, 0, time.UTC)
+	actual := nextTick(now, time.Minute)
+	if !actual.Equal(expected) {
+		t.Errorf("nextTick() result is wrong: expected: %s, actual: %s", expected, actual)
+	}
+
+	expected = time.Date(2018, 10, 1, 12, 35, 0, 0, time.UTC)
+	actual = nextTick(time.Date(2018, 10, 1, 12, 34, 0, 0, time.UTC), time.Minute)
+	if !actual.Equal(expected) {
+		t.Errorf("nextTick() result is wrong on a boundary: expected: %s, actual: %s", expected, actual)
+	}
+}
+
+func TestSchedulerSkipsTicksWhileBusy(t *testing.T) {
+	poller := newBlockingPoller()
+	s := newScheduler(time.Minute, false, poller.poll)
+
+	first := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	s.tick(first)
+	poller.waitStarted()
+	s.tick(first.Add(time.Minute))
+	s.tick(first.Add(2 * time.Minute))
+	poller.release()
+
+	third := first.Add(3 * time.Minute)
+	waitUntilIdle(s)
+	s.tick(third)
+	poller.waitStarted()
+	poller.release()
+	waitUntilIdle(s)
+
+	expected := []polled{{first, 0}, {third, 2}}
+	poller.assertPolled(t, expected)
+}
+
+func TestSchedulerDelaysTickWhileBusy(t *testing.T) {
+	poller := newBlockingPoller()
+	s := newScheduler(time.Minute, true, poller.poll)
+
+	first := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Minute)
+	s.tick(first)
+	poller.waitStarted()
+	s.tick(second)
+	s.tick(first.Add(2 * time.Minute))
+	poller.release()
+
+	poller.waitStarted()
+	poller.release()
+	waitUntilIdle(s)
+
+	expected := []polled{{first, 0}, {second, 1}}
+	poller.assertPolled(t, expected)
+}
+
+func TestSchedulerWaitsForRunningPollOnStop(t *testing.T) {
+	poller := newBlockingPoller()
+	poller.err = errors.New("failed to post metrics")
+	s := newScheduler(time.Minute, true, poller.poll)
+
+	first := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	s.tick(first)
+	poller.waitStarted()
+	s.tick(first.Add(time.Minute))
+	s.stop()
+
+	if err := s.wait(10 * time.Millisecond); err != errShutdownTimeout {
+		t.Errorf("wait() should time out while the poll is running: %v", err)
+	}
+
+	poller.release()
+	if err := s.wait(time.Second); err != poller.err {
+		t.Errorf("wait() should return the error of the last poll: %v", err)
+	}
+
+	// The delayed tick must be dropped on stop.
+	expected := []polled{{first, 0}}
+	poller.assertPolled(t, expected)
+}
+
+func TestSchedulerPollsOnStart(t *testing.T) {
+	poller := newBlockingPoller()
+	s := newScheduler(time.Hour, false, poller.poll)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-poller.started:
+	case <-time.After(time.Second):
+		t.Fatal("run() should poll right away without waiting for the next tick")
+	}
+	cancel()
+	<-done
+	poller.release()
+	if err := s.wait(time.Second); err != nil {
+		t.Errorf("wait() returned an error: %v", err)
+	}
+}
+
+type polled struct {
+	scheduledAt time.Time
+	skipped     int
+}
+
+type blockingPoller struct {
+	mu       sync.Mutex
+	polled   []polled
+	started  chan struct{}
+	released chan struct{}
+	err      error
+}
+
+func newBlockingPoller() *blockingPoller {
+	return &blockingPoller{
+		started:  make(chan struct{}),
+		released: make(chan struct{}),
+	}
+}
+
+func (p *blockingPoller) poll(ctx context.Context, scheduledAt time.Time, skipped int) error {
+	p.mu.Lock()
+	p.polled = append(p.polled, polled{scheduledAt, skipped})
+	p.mu.Unlock()
+
+	p.started <- struct{}{}
+	<-p.released
+
+	return p.err
+}
+
+func (p *blockingPoller) waitStarted() {
+	<-p.started
+}
+
+func (p *blockingPoller) release() {
+	p.released <- struct{}{}
+}
+
+func (p *blockingPoller) assertPolled(t *testing.T, expected []polled) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.polled) != len(expected) {
+		t.Fatalf("number of polls is wrong: expected: %d, actual: %d", len(expected), len(p.polled))
+	}
+	for i := range expected {
+		if !p.polled[i].scheduledAt.Equal(expected[i].scheduledAt) || p.polled[i].skipped != expected[i].skipped {
+			t.Errorf("poll #%d is wrong: expected: %v, actual: %v", i, expected[i], p.polled[i])
+		}
+	}
+}
+
+func waitUntilIdle(s *scheduler) {
+	for {
+		s.mu.Lock()
+		busy := s.busy
+		s.mu.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
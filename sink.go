@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink sends the samples collected in a check to a metrics backend.
+type Sink interface {
+	// Name is used in logs, e.g. "Datadog".
+	Name() string
+	Send(ctx context.Context, samples []sample) error
+}
+
+// fanOutSink sends samples to every sink concurrently. A sink failing or
+// panicking does not keep the others from sending.
+type fanOutSink struct {
+	sinks []Sink
+}
+
+func newFanOutSink(sinks ...Sink) *fanOutSink {
+	return &fanOutSink{sinks: sinks}
+}
+
+func (f *fanOutSink) Name() string {
+	names := make([]string, len(f.sinks))
+	for i, s := range f.sinks {
+		names[i] = s.Name()
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// Send returns an error if any of the sinks failed, after all of them have
+// finished.
+func (f *fanOutSink) Send(ctx context.Context, samples []sample) error {
+	errs := make([]error, len(f.sinks))
+
+	var wg sync.WaitGroup
+	for i, s := range f.sinks {
+		wg.Add(1)
+		go func(i int, s Sink) {
+			defer wg.Done()
+			errs[i] = sendToSink(ctx, s, samples)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, f.sinks[i].Name())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send metrics to %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func sendToSink(ctx context.Context, s Sink, samples []sample) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			log.Printf("failed to send metrics to %s: %s", s.Name(), err)
+		}
+	}()
+
+	if err := s.Send(ctx, samples); err != nil {
+		log.Printf("failed to send metrics to %s: %s", s.Name(), redactError(err))
+		return err
+	}
+	log.Printf("successfully sent metrics at %s to %s!", time.Now().Format(time.RFC3339), s.Name())
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	name string
+	err  error
+
+	mu      sync.Mutex
+	samples []sample
+}
+
+func (s *recordingSink) Name() string {
+	return s.name
+}
+
+func (s *recordingSink) Send(ctx context.Context, samples []sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = append(s.samples, samples...)
+
+	return s.err
+}
+
+type panickingSink struct{}
+
+func (panickingSink) Name() string {
+	return "panicking"
+}
+
+func (panickingSink) Send(ctx context.Context, samples []sample) error {
+	panic("broken sink")
+}
+
+func TestFanOutSinkIsolatesFailures(t *testing.T) {
+	ok := &recordingSink{name: "ok"}
+	failing := &recordingSink{name: "failing", err: errors.New("unavailable")}
+	samples := []sample{newSample(time.Now(), runningMetricName, 1, nil)}
+
+	err := newFanOutSink(failing, panickingSink{}, ok).Send(context.Background(), samples)
+	if err == nil {
+		t.Fatalf("Send() should return error")
+	}
+
+	expectedErr := "failed to send metrics to failing, panicking"
+	if err.Error() != expectedErr {
+		t.Errorf("Send() error is wrong: expected: %q, actual: %q", expectedErr, err.Error())
+	}
+	if len(ok.samples) != 1 {
+		t.Errorf("samples should be sent to the other sinks: %v", ok.samples)
+	}
+}
+
+func TestToDatadogMetrics(t *testing.T) {
+	now := time.Date(2018, 10, 1, 0, 0, 0, 0, time.UTC)
+	samples := []sample{
+		newSample(now, runningMetricName, 1, []tag{{"branch", "master"}}),
+		newSample(now, notRunningMetricName, 2, nil),
+	}
+
+	metrics := toDatadogMetrics(samples)
+	if len(metrics) != 2 {
+		t.Fatalf("toDatadogMetrics() result is wrong: %v", metrics)
+	}
+	if *metrics[0].Metric != runningMetricName || *metrics[0].Points[0][1] != 1 || *metrics[0].Points[0][0] != float64(now.Unix()) {
+		t.Errorf("toDatadogMetrics() result is wrong: %v", metrics[0])
+	}
+	if len(metrics[0].Tags) != 1 || metrics[0].Tags[0] != "branch:master" {
+		t.Errorf("toDatadogMetrics() tags are wrong: %v", metrics[0].Tags)
+	}
+	if *metrics[1].Metric != notRunningMetricName || *metrics[1].Points[0][1] != 2 {
+		t.Errorf("toDatadogMetrics() result is wrong: %v", metrics[1])
+	}
+}
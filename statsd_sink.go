@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Packets are kept under these sizes so that UDP datagrams are not
+// fragmented, and UDS datagrams fit in the default buffer of the agent.
+const (
+	statsdUDPMaxPacketSize = 1432
+	statsdUDSMaxPacketSize = 8192
+)
+
+// statsdSink sends samples to DogStatsD as gauges over UDP or a Unix domain
+// socket, batching as many as fit in a packet.
+type statsdSink struct {
+	network       string
+	address       string
+	maxPacketSize int
+	namespace     string
+	tags          []string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newStatsdSink returns a sink for address, which is host:port for UDP or
+// unix:///path/to/socket for UDS.
+func newStatsdSink(address, namespace string, tags []string) (*statsdSink, error) {
+	s := &statsdSink{
+		network:       "udp",
+		address:       address,
+		maxPacketSize: statsdUDPMaxPacketSize,
+		namespace:     namespace,
+		tags:          tags,
+	}
+
+	if strings.HasPrefix(address, "unix://") {
+		s.network = "unixgram"
+		s.address = strings.TrimPrefix(address, "unix://")
+		s.maxPacketSize = statsdUDSMaxPacketSize
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid --statsd-address: %s", err)
+	}
+
+	if namespace != "" && !strings.HasSuffix(namespace, ".") {
+		s.namespace += "."
+	}
+
+	return s, nil
+}
+
+func (s *statsdSink) Name() string {
+	return "DogStatsD"
+}
+
+func (s *statsdSink) Send(ctx context.Context, samples []sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The socket of the agent may not exist yet at startup, or be recreated
+	// on its restart, so connect lazily and reconnect after errors.
+	if s.conn == nil {
+		conn, err := net.Dial(s.network, s.address)
+		if err != nil {
+			return fmt.Errorf("failed to connect to DogStatsD: %s", err)
+		}
+		s.conn = conn
+	}
+
+	for _, packet := range s.packets(samples) {
+		if _, err := s.conn.Write(packet); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to write to DogStatsD: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// packets formats samples into newline-separated lines, packing them into
+// packets of up to maxPacketSize bytes. A line longer than that is sent
+// alone.
+func (s *statsdSink) packets(samples []sample) [][]byte {
+	var packets [][]byte
+	var buf bytes.Buffer
+
+	for _, m := range samples {
+		line := s.format(m)
+		if buf.Len() > 0 && buf.Len()+1+len(line) > s.maxPacketSize {
+			packets = append(packets, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+
+	return packets
+}
+
+func (s *statsdSink) format(m sample) string {
+	line := statsdNameReplacer.Replace(s.namespace+m.Name) + ":" + strconv.FormatFloat(m.Value, 'f', -1, 64) + "|g"
+
+	tags := make([]string, 0, len(s.tags)+len(m.Tags))
+	tags = append(tags, s.tags...)
+	for _, t := range m.Tags {
+		tags = append(tags, statsdTagReplacer.Replace(t.String()))
+	}
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+
+	return line
+}
+
+// statsdNameReplacer and statsdTagReplacer replace the characters which
+// delimit the fields of the DogStatsD protocol.
+var (
+	statsdNameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "\n", "_")
+	statsdTagReplacer  = strings.NewReplacer("|", "_", ",", "_", "\n", "_")
+)
+
+// parseStatsdTags parses the comma-separated constant tags of --statsd-tags.
+func parseStatsdTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, statsdTagReplacer.Replace(t))
+		}
+	}
+
+	return tags
+}
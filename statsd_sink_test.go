@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdSinkSendsOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := newStatsdSink(conn.LocalAddr().String(), "ci", []string{"env:test"})
+	if err != nil {
+		t.Fatalf("newStatsdSink() returned error: %s", err)
+	}
+
+	samples := []sample{newSample(time.Now(), runningMetricName, 2, []tag{{"branch", "feature|x"}})}
+	if err := sink.Send(context.Background(), samples); err != nil {
+		t.Fatalf("Send() returned error: %s", err)
+	}
+
+	expected := "ci.circleci.queue.running:2|g|#env:test,branch:feature_x"
+	if actual := readPacket(t, conn); actual != expected {
+		t.Errorf("packet is wrong: expected: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestStatsdSinkSendsOverUDS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := newStatsdSink("unix://"+path, "", nil)
+	if err != nil {
+		t.Fatalf("newStatsdSink() returned error: %s", err)
+	}
+
+	samples := []sample{newSample(time.Now(), truncatedMetricName, 0, nil)}
+	if err := sink.Send(context.Background(), samples); err != nil {
+		t.Fatalf("Send() returned error: %s", err)
+	}
+
+	expected := "circleci.queue.truncated:0|g"
+	if actual := readPacket(t, conn); actual != expected {
+		t.Errorf("packet is wrong: expected: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestStatsdSinkBatchesUnderMaxPacketSize(t *testing.T) {
+	sink, err := newStatsdSink("127.0.0.1:8125", "", nil)
+	if err != nil {
+		t.Fatalf("newStatsdSink() returned error: %s", err)
+	}
+
+	var samples []sample
+	for i := 0; i < 100; i++ {
+		samples = append(samples, newSample(time.Now(), runningMetricName, float64(i), newJobCount(createCircleCIJobWithLifeCycle("running")).toTags()))
+	}
+
+	packets := sink.packets(samples)
+	if len(packets) < 2 {
+		t.Fatalf("samples should be split into packets: %d", len(packets))
+	}
+
+	lines := 0
+	for _, packet := range packets {
+		if len(packet) > statsdUDPMaxPacketSize {
+			t.Errorf("packet is too large: %d bytes", len(packet))
+		}
+		lines += len(strings.Split(string(packet), "\n"))
+	}
+	if lines != len(samples) {
+		t.Errorf("number of lines is wrong: expected: %d, actual: %d", len(samples), lines)
+	}
+}
+
+func TestNewStatsdSinkRejectsInvalidAddress(t *testing.T) {
+	if _, err := newStatsdSink("localhost", "", nil); err == nil {
+		t.Errorf("newStatsdSink() should return error")
+	}
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, statsdUDSMaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("failed to read packet: %s", err)
+	}
+
+	return string(buf[:n])
+}